@@ -0,0 +1,362 @@
+package main
+
+// This file contains the management commands for generated commands:
+// cwc ai list|show|edit|rm|export|promote
+// Generated commands are stored as "ai-<uuid>-<prompt>.md" in the "ai" directory of the config directory,
+// the uuid (or a unique prefix of it) is used as the id of the command.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mistakenelf/teacup/markdown"
+)
+
+type aiCommandFile struct {
+	ID      string
+	Path    string
+	Prompt  string
+	Command Command
+}
+
+var aiCommandIdRegex = regexp.MustCompile(`^[0-9a-f-]{4,36}$`)
+
+// errAiCommandNotFound is returned when no generated command has the id
+var errAiCommandNotFound = errors.New("no generated command with the id")
+var aiCommandFileRegex = regexp.MustCompile(`^ai-([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})-(.*)\.md$`)
+
+func getAiCommandsPath() (string, error) {
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, "commands-wiki", "ai"), nil
+}
+
+// listAiCommandFiles returns all generated commands
+func listAiCommandFiles() ([]aiCommandFile, error) {
+	aiCommandsPath, err := getAiCommandsPath()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(aiCommandsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []aiCommandFile
+	for _, entry := range entries {
+		matches := aiCommandFileRegex.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		path := filepath.Join(aiCommandsPath, entry.Name())
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file := aiCommandFile{
+			ID:     matches[1],
+			Path:   path,
+			Prompt: strings.TrimSpace(matches[2]),
+		}
+		if commands := parseCommands(string(contents), true); len(commands) > 0 {
+			file.Command = commands[0]
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// findAiCommandFile returns the generated command with the id, the id can be shortened as long as it is unique
+func findAiCommandFile(id string) (aiCommandFile, error) {
+	files, err := listAiCommandFiles()
+	if err != nil {
+		return aiCommandFile{}, err
+	}
+	var found []aiCommandFile
+	for _, file := range files {
+		if strings.HasPrefix(file.ID, id) {
+			found = append(found, file)
+		}
+	}
+	if len(found) == 0 {
+		return aiCommandFile{}, fmt.Errorf("%w %s, run \"cwc ai list\" to see all ids", errAiCommandNotFound, id)
+	}
+	if len(found) > 1 {
+		return aiCommandFile{}, fmt.Errorf("the id %s matches %d commands, use more of the id", id, len(found))
+	}
+	return found[0], nil
+}
+
+// syncAiCommandsInIndex replaces the generated commands in the index with the ones in the "ai" directory
+func syncAiCommandsInIndex() error {
+	files, err := listAiCommandFiles()
+	if err != nil {
+		return err
+	}
+	var aiCommands []Command
+	for _, file := range files {
+		contents, err := os.ReadFile(file.Path)
+		if err != nil {
+			return err
+		}
+		fileCommands := parseCommands(string(contents), true)
+		setCommandIDs(fileCommands, aiCommandPath(file.Path))
+		for _, cmd := range fileCommands {
+			cmd.SourceFile = file.Path
+			aiCommands = append(aiCommands, cmd)
+		}
+	}
+
+	return modifyIndex(func(commands []Command) []Command {
+		var synced []Command
+		for _, cmd := range commands {
+			if !cmd.AiGenerated {
+				synced = append(synced, cmd)
+			}
+		}
+		return append(synced, aiCommands...)
+	})
+}
+
+// runAiManagementCommand runs "cwc ai <subcommand>", it returns false if args is not a management command
+// but a prompt
+func runAiManagementCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	subcommand := args[0]
+	switch subcommand {
+	case "list", "ls":
+		if len(args) != 1 {
+			return false
+		}
+		files, err := listAiCommandFiles()
+		if err != nil {
+			log.Fatal("Failed to list the generated commands", "error", err)
+		}
+		if len(files) == 0 {
+			fmt.Println("There are no generated commands, create one with \"cwc ai <prompt>\"")
+		}
+		for _, file := range files {
+			fmt.Printf("%s  %s  (%s)\n", file.ID[:8], file.Command.CmdTitle, file.Prompt)
+		}
+	case "show", "edit", "rm", "export", "promote":
+		// A single id after these is a management command, anything else is a prompt like "show disk usage"
+		if len(args) < 2 || len(args) > 3 || (len(args) == 3 && subcommand != "export") || !aiCommandIdRegex.MatchString(args[1]) {
+			return false
+		}
+		file, err := findAiCommandFile(args[1])
+		// Words like "cafe" or "add" look like ids, without a command with that id they are part of the prompt
+		if errors.Is(err, errAiCommandNotFound) {
+			return false
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch subcommand {
+		case "show":
+			showAiCommandFile(file)
+		case "edit":
+			editAiCommandFile(file)
+		case "rm":
+			removeAiCommandFile(file)
+		case "export":
+			var target string
+			if len(args) == 3 {
+				target = args[2]
+			}
+			exportAiCommandFile(file, target)
+		case "promote":
+			err = promoteAiCommandFile(file)
+			if err != nil {
+				log.Fatal("Failed to promote the command", "error", err)
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func showAiCommandFile(file aiCommandFile) {
+	contents, err := os.ReadFile(file.Path)
+	if err != nil {
+		log.Fatal("Failed to read the command", "error", err)
+	}
+	render, err := markdown.RenderMarkdown(100, string(contents))
+	if err != nil {
+		fmt.Println(string(contents))
+		return
+	}
+	fmt.Println(render)
+}
+
+func editAiCommandFile(file aiCommandFile) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := execCommand(editor, []string{file.Path})
+	if cmd.ProcessState.ExitCode() != 0 {
+		log.Fatal("The editor exited with an error, not updating the index")
+	}
+	contents, err := os.ReadFile(file.Path)
+	if err != nil {
+		log.Fatal("Failed to read the command", "error", err)
+	}
+	for _, problem := range validateCommandMarkdown(string(contents)) {
+		log.Warn(problem)
+	}
+	err = syncAiCommandsInIndex()
+	if err != nil {
+		log.Fatal("Failed to update the index", "error", err)
+	}
+}
+
+func removeAiCommandFile(file aiCommandFile) {
+	err := os.Remove(file.Path)
+	if err != nil {
+		log.Fatal("Failed to remove the command", "error", err)
+	}
+	err = syncAiCommandsInIndex()
+	if err != nil {
+		log.Fatal("Failed to update the index", "error", err)
+	}
+	log.Info("Removed the command", "title", file.Command.CmdTitle)
+}
+
+// exportAiCommandFile writes the markdown of the command to target, or to stdout if target is empty
+func exportAiCommandFile(file aiCommandFile, target string) {
+	contents, err := os.ReadFile(file.Path)
+	if err != nil {
+		log.Fatal("Failed to read the command", "error", err)
+	}
+	if target == "" {
+		fmt.Print(string(contents))
+		return
+	}
+	err = os.WriteFile(target, contents, 0644)
+	if err != nil {
+		log.Fatal("Failed to export the command", "error", err)
+	}
+}
+
+// promoteAiCommandFile adds the command to the local clone of the wiki on a new branch, so it can be pushed
+// and opened as a contribution
+func promoteAiCommandFile(file aiCommandFile) (err error) {
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		return err
+	}
+	repo_name, err := GetRepoName()
+	if err != nil {
+		return err
+	}
+	repo, err := GetRepo()
+	if err != nil {
+		return err
+	}
+	if sourceKindOf(repo) != gitSource {
+		return fmt.Errorf("%s is not a git repository, commands can only be promoted to a git repository", repo)
+	}
+	repo_path := filepath.Join(configPath, "commands-wiki", "repos", repo_name)
+	if _, err := os.Stat(repo_path); err != nil {
+		return fmt.Errorf("the wiki has not been cloned yet, run \"cwc update\" first")
+	}
+	contents, err := os.ReadFile(file.Path)
+	if err != nil {
+		return err
+	}
+
+	slug := strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(file.Command.CmdTitle), "-"), "-")
+	if slug == "" {
+		slug = file.ID[:8]
+	}
+	// The command is added to the directory the index is built from, so it shows up after the next update
+	relativePath := filepath.Join(filepath.FromSlash(getContentRoot()), slug+".md")
+	targetPath := filepath.Join(repo_path, relativePath)
+	if _, err := os.Stat(targetPath); err == nil {
+		return fmt.Errorf("%s already exists in the wiki", relativePath)
+	}
+
+	// The wiki uses frontmatter for the title and description of every page, the values are quoted as titles like
+	// "Kill process: by name" would not be valid yaml otherwise. Go quoted strings are valid double quoted yaml.
+	title := strconv.Quote(file.Command.CmdTitle)
+	description := strconv.Quote(strings.ReplaceAll(file.Command.CmdDescription, "\n", " "))
+	page := "---\ntitle: " + title + "\ndescription: " + description + "\n---\n\n" + strings.TrimLeft(string(contents), "\n")
+
+	branchName := "cwc/" + slug
+	// The index is built from a branch or from a pinned tag or commit without a branch
+	r, err := git.PlainOpen(repo_path)
+	if err != nil {
+		return err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	worktree, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	branchRef := plumbing.NewBranchReferenceName(branchName)
+	if _, err := r.Reference(branchRef, false); err == nil {
+		return fmt.Errorf("the branch %s already exists in %s", branchName, repo_path)
+	}
+	err = worktree.Checkout(&git.CheckoutOptions{Branch: branchRef, Hash: head.Hash(), Create: true})
+	if err != nil {
+		return fmt.Errorf("failed to create the branch %s: %w", branchName, err)
+	}
+	// Go back to what the index is built from whatever happens, otherwise updates stop working
+	defer func() {
+		if err != nil {
+			// Leave nothing of the failed promotion behind so the next update and promotion work
+			os.Remove(targetPath)
+			worktree.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.MixedReset})
+		}
+		restore := &git.CheckoutOptions{Hash: head.Hash()}
+		if head.Name().IsBranch() {
+			restore = &git.CheckoutOptions{Branch: head.Name()}
+		}
+		if checkoutErr := worktree.Checkout(restore); checkoutErr != nil {
+			log.Error("Failed to check out the wiki again, run \"cwc update\" after fixing it", "path", repo_path, "error", checkoutErr)
+		}
+		if err != nil {
+			r.Storer.RemoveReference(branchRef)
+		}
+	}()
+
+	err = os.WriteFile(targetPath, []byte(page), 0644)
+	if err != nil {
+		return err
+	}
+	_, err = worktree.Add(filepath.ToSlash(relativePath))
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", relativePath, err)
+	}
+	// The author is read from the git config of the user, like git commit does
+	_, err = worktree.Commit("Add "+file.Command.CmdTitle, &git.CommitOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to commit %s, set user.name and user.email in your git config: %w", relativePath, err)
+	}
+
+	fmt.Println()
+	fmt.Println("The command was committed to the branch " + branchName + " of " + repo_path)
+	fmt.Println("Push it to your fork and open a pull request:")
+	fmt.Println("  git -C " + repo_path + " push <your-fork> " + branchName)
+	fmt.Println("Once it has been merged you can remove the generated command with \"cwc ai rm " + file.ID[:8] + "\"")
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+// This file contains the retrieval of existing commands from the index for "cwc ai"
+// The most relevant commands are sent to the model as examples, and if one of them already answers the prompt
+// the user is offered to use it instead of generating a new command.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// retrievalStopWords are ignored when looking for commands matching the prompt, they match almost every command
+var retrievalStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "from": true, "into": true, "that": true,
+	"this": true, "how": true, "command": true, "all": true, "using": true, "use": true,
+}
+
+// retrieveRelevantCommands returns the commands from the index that match the prompt best
+func retrieveRelevantCommands(commands []Command, prompt string) []scoredCommand {
+	var queryWords []string
+	for _, word := range strings.Fields(strings.ToLower(prompt)) {
+		if len(word) < 3 || retrievalStopWords[word] {
+			continue
+		}
+		queryWords = append(queryWords, word)
+	}
+	if len(queryWords) == 0 {
+		return nil
+	}
+
+	limit, err := strconv.Atoi(GetValueNoError("ai-context-commands", "5"))
+	if err != nil {
+		limit = 5
+	}
+	scored := scoreCommands(commands, strings.Join(queryWords, " "))
+	if len(scored) > limit {
+		scored = scored[:limit]
+	}
+	// Normalize the score by the length of the query so the threshold does not depend on the prompt length
+	for i := range scored {
+		scored[i].score /= float32(len(queryWords))
+	}
+	return scored
+}
+
+// offerExistingCommand asks the user if one of the commands that match the prompt well should be used instead
+// of generating a new one, it returns the chosen command or nil
+func offerExistingCommand(relevant []scoredCommand) *Command {
+	threshold, err := strconv.ParseFloat(GetValueNoError("ai-reuse-score", "1.5"), 32)
+	if err != nil {
+		threshold = 1.5
+	}
+	var candidates []Command
+	for _, scored := range relevant {
+		if scored.score >= float32(threshold) {
+			candidates = append(candidates, scored.command)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	fmt.Println("These commands from the wiki might already do what you want:")
+	for i, cmd := range candidates {
+		fmt.Printf("  %d) %s\n", i+1, cmd.CmdTitle)
+	}
+	fmt.Print("Enter a number to use that command, or press enter to generate a new one: ")
+	var input string
+	fmt.Scanln(&input)
+	choice, err := strconv.Atoi(input)
+	if err != nil || choice < 1 || choice > len(candidates) {
+		return nil
+	}
+	return &candidates[choice-1]
+}
+
+// relevantCommandsPrompt formats the commands so they can be added to the system prompt
+func relevantCommandsPrompt(relevant []scoredCommand) string {
+	if len(relevant) == 0 {
+		return ""
+	}
+	prompt := "\nThese are existing commands from the wiki that are related to the request. Follow their style, reuse their approach where it fits and do not repeat them if the request asks for something different:\n"
+	for _, scored := range relevant {
+		cmd := scored.command
+		prompt += "\n### " + cmd.CmdTitle + "\n" + cmd.CmdDescription + "\n```" + cmd.Language + "\n" + cmd.Content + "\n```\n"
+	}
+	return prompt
+}
@@ -0,0 +1,165 @@
+package main
+
+// This file contains the review step of "cwc ai", the generated command is only saved when the user accepts it.
+// Before that it can be regenerated with extra instructions, edited in $EDITOR or discarded.
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type aiReviewKeymap struct {
+	Accept     key.Binding
+	Regenerate key.Binding
+	Edit       key.Binding
+	Discard    key.Binding
+}
+
+// ShortHelp returns keybindings to be shown in the mini help view. It's part
+// of the key.Map interface.
+func (k aiReviewKeymap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Accept, k.Regenerate, k.Edit, k.Discard}
+}
+
+// FullHelp returns keybindings for the expanded help view. It's part of the
+// key.Map interface.
+func (k aiReviewKeymap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Accept, k.Regenerate}, // first column
+		{k.Edit, k.Discard},      // second column
+	}
+}
+
+var AiReviewKeymap = aiReviewKeymap{
+	Accept: key.NewBinding(
+		key.WithKeys("a", "enter"),
+		key.WithHelp("a", "accept and save"),
+	),
+	Regenerate: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "regenerate with instructions"),
+	),
+	Edit: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("e", "edit in $EDITOR"),
+	),
+	Discard: key.NewBinding(
+		key.WithKeys("d", "q", "ctrl+c", "esc"),
+		key.WithHelp("d", "discard"),
+	),
+}
+
+type aiEditorFinishedMsg struct {
+	path string
+	err  error
+}
+
+// updateReview handles the key presses once the command has been generated
+func (m aiCommandGenerationModel) updateReview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	if m.isReadingInstructions {
+		switch msg.Type {
+		case tea.KeyCtrlC:
+			return m, tea.Quit
+		case tea.KeyEsc:
+			m.isReadingInstructions = false
+		case tea.KeyEnter:
+			m.isReadingInstructions = false
+			return m, m.regenerate(m.textInput.Value())
+		default:
+			m.textInput, cmd = m.textInput.Update(msg)
+		}
+		return m, cmd
+	}
+
+	m.status = ""
+	switch {
+	case key.Matches(msg, m.keys.Accept):
+		if len(m.problems) > 0 {
+			m.status = "The command has problems, edit or regenerate it before saving it"
+			return m, nil
+		}
+		m.isAccepted = true
+		return m, tea.Quit
+	case key.Matches(msg, m.keys.Regenerate):
+		m.isReadingInstructions = true
+		m.textInput.SetValue("")
+	case key.Matches(msg, m.keys.Edit):
+		return m, m.openEditor()
+	case key.Matches(msg, m.keys.Discard):
+		m.cancel()
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+// regenerate asks the model for a new version of the command that follows the instructions
+func (m *aiCommandGenerationModel) regenerate(instructions string) tea.Cmd {
+	prompt := "Write the command again."
+	if instructions != "" {
+		prompt = "Write the command again with these changes: " + instructions
+	}
+	m.conversation = append(m.conversation,
+		completionMessage{Role: "assistant", Content: m.generated},
+		completionMessage{Role: "user", Content: prompt},
+	)
+	m.repairAttempt = 0
+	return m.startRequest()
+}
+
+// openEditor opens the generated markdown in $EDITOR
+func (m aiCommandGenerationModel) openEditor() tea.Cmd {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	file, err := os.CreateTemp("", "cwc-ai-*.md")
+	if err != nil {
+		return func() tea.Msg { return aiEditorFinishedMsg{err: err} }
+	}
+	_, err = file.WriteString(m.generated)
+	file.Close()
+	if err != nil {
+		return func() tea.Msg { return aiEditorFinishedMsg{path: file.Name(), err: err} }
+	}
+	return tea.ExecProcess(exec.Command(editor, file.Name()), func(err error) tea.Msg {
+		return aiEditorFinishedMsg{path: file.Name(), err: err}
+	})
+}
+
+// applyEditedMarkdown reads the markdown back after the editor closed and validates it again
+func (m *aiCommandGenerationModel) applyEditedMarkdown(msg aiEditorFinishedMsg) {
+	if msg.path != "" {
+		defer os.Remove(msg.path)
+	}
+	if msg.err != nil {
+		m.status = "Failed to edit the command: " + msg.err.Error()
+		return
+	}
+	contents, err := os.ReadFile(msg.path)
+	if err != nil {
+		m.status = "Failed to read the edited command: " + err.Error()
+		return
+	}
+	m.generated = string(contents)
+	m.problems = validateCommandMarkdown(m.generated)
+	m.renderGenerated()
+}
+
+func (m aiCommandGenerationModel) reviewView() string {
+	var view string
+	for _, problem := range m.problems {
+		view += execErrorStyle.Render("✗ "+problem) + "\n"
+	}
+	if m.isReadingInstructions {
+		view += "Regenerate with: " + m.textInput.View()
+	} else if m.status != "" {
+		view += m.status
+	} else {
+		view += m.help.View(m.keys)
+	}
+	return view
+}
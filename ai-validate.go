@@ -0,0 +1,122 @@
+package main
+
+// This file contains the validation of generated command markdown
+// Every problem found is described in a way that can be sent back to the model so it can repair the markdown.
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// validateCommandMarkdown returns the problems that stop the markdown from being used as a command
+func validateCommandMarkdown(contents string) []string {
+	var problems []string
+
+	if !regexp.MustCompile(`(?m)^### \S`).MatchString(contents) {
+		return append(problems, `there is no title, the command has to start with a "### " heading`)
+	}
+	if strings.Count(contents, "\n```")%2 != 0 {
+		problems = append(problems, "a code block is not closed with ```")
+	}
+
+	commands := parseCommands(contents, true)
+	if len(commands) != 1 {
+		problems = append(problems, fmt.Sprintf("there should be exactly one \"### \" title, found %d", len(commands)))
+	}
+	for _, cmd := range commands {
+		if strings.TrimSpace(cmd.Content) == "" {
+			problems = append(problems, fmt.Sprintf("%q has no code block with the command", cmd.CmdTitle))
+			continue
+		}
+		problems = append(problems, validateCommandMetadata(cmd)...)
+		problems = append(problems, checkCommandSyntax(cmd)...)
+	}
+	return problems
+}
+
+// validateCommandMetadata checks that every placeholder is described and that the validations compile
+func validateCommandMetadata(cmd Command) []string {
+	var problems []string
+	checked := make(map[string]bool)
+	for _, variable := range cmd.Variables {
+		if checked[variable] {
+			continue
+		}
+		checked[variable] = true
+
+		variableMetadata, ok := cmd.Metadata[variable]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("the placeholder <%s> has no metadata line like [%s]: <> (placeholder=... desc=\"...\")", variable, variable))
+			continue
+		}
+		if problem := validateVariableValidation(variable, variableMetadata["validation"]); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// validateVariableValidation checks that the validation of the variable compiles, it returns the problem if not
+func validateVariableValidation(variable string, validation string) string {
+	if validation == "" {
+		return ""
+	}
+	validationType, validationData, _ := strings.Cut(validation, " ")
+	switch validationType {
+	case "regex", "file":
+		_, err := regexp.Compile("^" + validationData + "$")
+		if err != nil {
+			return fmt.Sprintf("the validation of <%s> is not a valid regex: %s", variable, err)
+		}
+		return ""
+	}
+	return fmt.Sprintf("the validation of <%s> uses the unknown type %q, only regex and file are supported", variable, validationType)
+}
+
+// syntaxCheckers are the programs used to check the syntax of a command, the command is passed on stdin
+var syntaxCheckers = map[string][][]string{
+	"":       {{"bash", "-n"}, {"shellcheck", "--shell=bash", "--severity=error", "-"}},
+	"bash":   {{"bash", "-n"}, {"shellcheck", "--shell=bash", "--severity=error", "-"}},
+	"shell":  {{"bash", "-n"}, {"shellcheck", "--shell=bash", "--severity=error", "-"}},
+	"sh":     {{"sh", "-n"}, {"shellcheck", "--shell=sh", "--severity=error", "-"}},
+	"zsh":    {{"zsh", "-n"}},
+	"fish":   {{"fish", "--no-execute"}},
+	"python": {{"python3", "-c", "import ast, sys; ast.parse(sys.stdin.read())"}},
+	"py":     {{"python3", "-c", "import ast, sys; ast.parse(sys.stdin.read())"}},
+}
+
+// checkCommandSyntax runs the syntax checkers for the language of the command that are installed
+func checkCommandSyntax(cmd Command) []string {
+	// Placeholders like <name> would be read as redirects, replace them with a plain word
+	content := regexp.MustCompile(`[{<]([A-Za-z\d\-_\/]+)[>}]`).ReplaceAllString(cmd.Content, "placeholder")
+
+	var problems []string
+	for _, checker := range syntaxCheckers[cmd.Language] {
+		if _, err := exec.LookPath(checker[0]); err != nil {
+			continue
+		}
+		var output bytes.Buffer
+		check := exec.Command(checker[0], checker[1:]...)
+		check.Stdin = strings.NewReader(content)
+		check.Stdout = &output
+		check.Stderr = &output
+		err := check.Run()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s reports a syntax error in the command: %s", checker[0], strings.TrimSpace(output.String())))
+		}
+	}
+	return problems
+}
+
+// repairPrompt asks the model to fix the problems found in the markdown it generated
+func repairPrompt(problems []string) string {
+	prompt := "The markdown you wrote can not be used as a command because of these problems:\n"
+	for _, problem := range problems {
+		prompt += "- " + problem + "\n"
+	}
+	prompt += "Fix the problems and reply with the complete corrected markdown only, formatted like the example."
+	return prompt
+}
@@ -7,50 +7,115 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
 	"github.com/mistakenelf/teacup/markdown"
-	"github.com/sashabaranov/go-openai"
 	uuid "github.com/satori/go.uuid"
 )
 
 type aiCommandGenerationModel struct {
-	prompt   string
-	markdown markdown.Model
-	program  *tea.Program
+	prompt                string
+	provider              completionProvider
+	conversation          []completionMessage
+	generated             string
+	rendered              string
+	problems              []string
+	repairAttempt         int
+	events                chan tea.Msg
+	ctx                   context.Context
+	cancel                context.CancelFunc
+	err                   error
+	markdown              markdown.Model
+	keys                  aiReviewKeymap
+	help                  help.Model
+	textInput             textinput.Model
+	isReviewing           bool
+	isReadingInstructions bool
+	isAccepted            bool
+	status                string
 }
 
+// relevantCommandsFor returns the commands of the index that match the description, it is a variable so tests do
+// not depend on the index
+var relevantCommandsFor = func(description string) []scoredCommand {
+	indexedCommands, err := readIndex()
+	if err != nil {
+		return nil
+	}
+	return retrieveRelevantCommands(indexedCommands, description)
+}
+
+// environmentContext returns the summary of this machine that is sent with the prompt, it is a variable so tests
+// do not depend on the machine they run on
+var environmentContext = environmentContextPrompt
+
 func runCommandAiCommandGeneration(description string) {
-	tryReadOpenAIKey()
+	provider, err := newCompletionProvider()
+	if err != nil {
+		log.Fatal("Failed to set up the ai provider", "error", err)
+	}
 
-	b := newAiCommandGeneration(description)
-	p := tea.NewProgram(b, tea.WithAltScreen())
-	go func() {
-		for {
-			<-time.After(100 * time.Millisecond)
-			p.Send(timeMsg(time.Now()))
-		}
-	}()
+	// Ground the generation in the commands we already have
+	relevant := relevantCommandsFor(description)
+	if existing := offerExistingCommand(relevant); existing != nil {
+		showCommmand(*existing)
+		return
+	}
+	environment := environmentContext()
+	conversation := []completionMessage{
+		{
+			Role:    "system",
+			Content: "You will get a description for a command, you should then write a title, short description and a description of each argument for this command. Where applicable use variables like `<variable_name>` in the commands. The markdown should be formatted like below:\n" + markdownExample + cwcInstructions + relevantCommandsPrompt(relevant) + environment,
+		},
+		{
+			Role:    "user",
+			Content: description,
+		},
+	}
+	b := newAiCommandGeneration(description, provider, conversation)
+	generated, accepted := runAiCommandGenerationModel(b)
+	if !accepted {
+		log.Info("Discarded the generated command")
+		return
+	}
+	saveAiCommand(description, generated)
+}
 
-	if _, err := p.Run(); err != nil {
+// runAiCommandGenerationModel streams the command and lets the user review it, it returns the markdown and
+// true if the user accepted the command
+func runAiCommandGenerationModel(b aiCommandGenerationModel) (string, bool) {
+	// Stops the request if the user quits while the command is being generated
+	defer b.cancel()
+
+	model, err := runProgram(b)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	if !isAiGenCompleted {
-		return
+	m := model.(aiCommandGenerationModel)
+	if m.err != nil {
+		log.Error("Failed to generate the command", "provider", m.provider.Name(), "model", m.provider.Model(), "error", m.err)
 	}
+	// Only commands the user accepted are saved
+	return m.generated, m.isAccepted
+}
 
+// saveAiCommand saves the accepted command in the config dir, adds it to the index and shows it
+func saveAiCommand(description string, contents string) {
 	// Save the markdown to a file in the config dir
-	configPath, err := os.UserConfigDir()
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		log.Fatal("Failed to get config directory", "error", err)
 	}
-	markdownFilePath := filepath.Join(configPath, "commands-wiki", "ai", "ai-"+uuid.NewV4().String()+"-"+description+".md")
+	fileNameReplacer := strings.NewReplacer("/", "_", "\\", "_", "\n", " ")
+	markdownFilePath := filepath.Join(configPath, "commands-wiki", "ai", "ai-"+uuid.NewV4().String()+"-"+fileNameReplacer.Replace(description)+".md")
 	err = os.MkdirAll(filepath.Dir(markdownFilePath), 0744)
 	if err != nil {
 		log.Fatal("Failed to create markdown file parent directories", "error", err)
@@ -60,7 +125,7 @@ func runCommandAiCommandGeneration(description string) {
 	if err != nil {
 		log.Fatal("Failed to create markdown file", "error", err)
 	}
-	_, err = markdownFile.WriteString(currentGptMarkdown)
+	_, err = markdownFile.WriteString(contents)
 	if err != nil {
 		log.Fatal("Failed to write markdown content", "error", err)
 	}
@@ -70,42 +135,42 @@ func runCommandAiCommandGeneration(description string) {
 	}
 
 	// Add command to the index and save
-	commands, err := readIndex()
-	if err != nil {
-		log.Fatal("Failed to read index", "error", err)
-	}
-	commands = append(commands, markdownToCommand(currentGptMarkdown))
-	err = writeIndex(commands)
+	cmd := markdownToCommand(contents)
+	cmd.ID = commandID(aiCommandPath(markdownFilePath), cmd.CmdTitle)
+	cmd.SourceFile = markdownFilePath
+	err = modifyIndex(func(commands []Command) []Command {
+		return append(commands, cmd)
+	})
 	if err != nil {
-		log.Fatal("Failed to write index", "error", err)
+		log.Fatal("Failed to add the command to the index", "error", err)
 	}
 
-	cmd := markdownToCommand(currentGptMarkdown)
 	showCommmand(cmd)
 }
 
-var currentDisplayMarkdown string
-var currentGptMarkdown string
-var openAiToken string
-
-func tryReadOpenAIKey() {
-	// Try to read the openai key
-	openAiToken = os.Getenv("OPENAI_API_KEY")
-	if openAiToken == "" {
-		log.Fatal("OPENAI_API_KEY is not set")
-	}
-}
+func newAiCommandGeneration(prompt string, provider completionProvider, conversation []completionMessage) aiCommandGenerationModel {
+	ti := textinput.New()
+	ti.Placeholder = "make it work on macOS as well"
+	ti.Focus()
+	ti.CharLimit = 500
+	ti.Width = 60
 
-func newAiCommandGeneration(prompt string) aiCommandGenerationModel {
+	ctx, cancel := context.WithCancel(context.Background())
 	return aiCommandGenerationModel{
-		prompt: prompt,
+		prompt:       prompt,
+		provider:     provider,
+		conversation: conversation,
+		events:       streamCompletion(ctx, provider, conversation),
+		ctx:          ctx,
+		cancel:       cancel,
+		keys:         AiReviewKeymap,
+		help:         help.New(),
+		textInput:    ti,
 	}
 }
 
 func (m aiCommandGenerationModel) Init() tea.Cmd {
-	// Run the gpt ai
-	go startCompletion(m)
-	return nil
+	return waitForAiEvent(m.events)
 }
 
 const markdownExample = `
@@ -136,82 +201,208 @@ Examples for these are:
 The regex matches will wrap the regex in "^" and "$", so please keep that in mind.
 `
 
-var isAiGenCompleted = false
+// aiChunkMsg is a piece of the response of the model
+type aiChunkMsg string
+
+// aiRetryMsg is sent when the request failed and will be sent again after delay
+type aiRetryMsg struct {
+	attempt int
+	delay   time.Duration
+	err     error
+}
+
+// aiErrorMsg is sent when the request failed and will not be retried
+type aiErrorMsg struct {
+	err error
+}
+
+// aiDoneMsg is sent when the response of the model is complete
+type aiDoneMsg struct{}
+
+// completionRetryDelay is how long to wait before the first retry, it doubles with every retry
+var completionRetryDelay = time.Second
 
-func startCompletion(m aiCommandGenerationModel) {
-	c := openai.NewClient(openAiToken)
-	ctx := context.Background()
-	req := openai.ChatCompletionRequest{
-		Model:     GetValueNoError("openai-model", "gpt-4-1106-preview"),
+// streamCompletion sends the messages to the model, the response and the errors are sent on the returned
+// channel as tea messages, the channel is closed when the request is done
+func streamCompletion(ctx context.Context, provider completionProvider, messages []completionMessage) chan tea.Msg {
+	timeoutSeconds, err := strconv.Atoi(GetValueNoError("ai-timeout", "120"))
+	if err != nil {
+		timeoutSeconds = 120
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	retries, err := strconv.Atoi(GetValueNoError("ai-retries", "3"))
+	if err != nil {
+		retries = 3
+	}
+
+	events := make(chan tea.Msg)
+	send := func(msg tea.Msg) bool {
+		select {
+		case events <- msg:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	go func() {
+		defer close(events)
+		for attempt := 0; ; attempt++ {
+			received, err := streamCompletionAttempt(ctx, provider, messages, timeout, send)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			// Only retry when nothing was shown yet, otherwise the response would be written twice
+			if received || attempt >= retries || !isRetryableCompletionError(err) {
+				send(aiErrorMsg{err: err})
+				return
+			}
+			delay := completionRetryDelay << attempt
+			if !send(aiRetryMsg{attempt: attempt + 1, delay: delay, err: err}) {
+				return
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// streamCompletionAttempt sends the request once, it returns if any of the response was received
+func streamCompletionAttempt(ctx context.Context, provider completionProvider, messages []completionMessage, timeout time.Duration, send func(tea.Msg) bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req := completionRequest{
 		MaxTokens: 1024,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role:    "system",
-				Content: "You will get a description for a command, you should then write a title, short description and a description of each argument for this command. Where applicable use variables like `<variable_name>` in the commands. The markdown should be formatted like below:\n" + markdownExample,
-			},
-			{
-				Role:    "user",
-				Content: m.prompt,
-			},
-		},
-		Stream: true,
+		Messages:  messages,
 	}
-	stream, err := c.CreateChatCompletionStream(ctx, req)
+	stream, err := provider.CreateCompletionStream(ctx, req)
 	if err != nil {
-		fmt.Printf("CompletionStream error: %v\n", err)
-		return
+		return false, completionTimeoutError(ctx, err, timeout)
 	}
 	defer stream.Close()
 
+	received := false
 	for {
-		response, err := stream.Recv()
+		deltaContent, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
-			render, err := markdown.RenderMarkdown(m.markdown.Viewport.Width, currentGptMarkdown)
-			if err == nil {
-				currentDisplayMarkdown = render
-				isAiGenCompleted = true
-			} else {
-				log.Fatal("Failed to render markdown", "error", err)
-			}
-			return
+			send(aiDoneMsg{})
+			return received, nil
 		}
-
 		if err != nil {
-			log.Fatal("Stream error", "error", err)
-			return
+			return received, completionTimeoutError(ctx, err, timeout)
 		}
-
-		deltaContent := response.Choices[0].Delta.Content
 		if deltaContent != "" {
-			currentGptMarkdown += deltaContent + ""
-			render, err := markdown.RenderMarkdown(m.markdown.Viewport.Width, currentGptMarkdown)
-			if err == nil {
-				currentDisplayMarkdown = render
+			received = true
+			if !send(aiChunkMsg(deltaContent)) {
+				return received, ctx.Err()
 			}
 		}
 	}
 }
 
-type timeMsg time.Time
+// completionTimeoutError explains the error if it was caused by the request timeout
+func completionTimeoutError(ctx context.Context, err error, timeout time.Duration) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("the model did not respond within %s, increase ai-timeout to wait longer: %w", timeout, err)
+	}
+	return err
+}
+
+// waitForAiEvent waits for the next message of the request
+func waitForAiEvent(events chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-events
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
+
+// startRequest sends the conversation to the model again
+func (m *aiCommandGenerationModel) startRequest() tea.Cmd {
+	m.generated = ""
+	m.err = nil
+	m.isReviewing = false
+	m.events = streamCompletion(m.ctx, m.provider, m.conversation)
+	return waitForAiEvent(m.events)
+}
+
+// finishCompletion validates the generated command, asks the model to repair it or lets the user review it
+func (m *aiCommandGenerationModel) finishCompletion() tea.Cmd {
+	repairAttempts, err := strconv.Atoi(GetValueNoError("ai-repair-attempts", "2"))
+	if err != nil {
+		repairAttempts = 2
+	}
+
+	// Make sure we can use what the model wrote, otherwise ask it to fix it
+	m.problems = validateCommandMarkdown(m.generated)
+	if len(m.problems) > 0 && m.repairAttempt < repairAttempts {
+		m.repairAttempt++
+		m.status = fmt.Sprintf("The command has %d problem(s), asking the model to fix them (attempt %d/%d)", len(m.problems), m.repairAttempt, repairAttempts)
+		m.conversation = append(m.conversation,
+			completionMessage{Role: "assistant", Content: m.generated},
+			completionMessage{Role: "user", Content: repairPrompt(m.problems)},
+		)
+		return m.startRequest()
+	}
+
+	m.status = ""
+	m.isReviewing = true
+	return nil
+}
+
+func (m *aiCommandGenerationModel) renderGenerated() {
+	render, err := markdown.RenderMarkdown(m.markdown.Viewport.Width, m.generated)
+	if err == nil {
+		m.rendered = render
+	}
+}
 
 func (m aiCommandGenerationModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		// Set the size to a bit smaller then we need
-		cmd := m.markdown.SetSize(msg.Width-2, msg.Height-6)
+		cmd := m.markdown.SetSize(msg.Width-2, msg.Height-8)
 		cmds = append(cmds, cmd)
+		m.renderGenerated()
+	case aiChunkMsg:
+		m.generated += string(msg)
+		m.renderGenerated()
+		return m, waitForAiEvent(m.events)
+	case aiRetryMsg:
+		m.status = fmt.Sprintf("The request failed (%s), retrying in %s (attempt %d)", msg.err, msg.delay, msg.attempt)
+		return m, waitForAiEvent(m.events)
+	case aiErrorMsg:
+		m.err = msg.err
+		m.status = ""
+		return m, nil
+	case aiDoneMsg:
+		return m, m.finishCompletion()
+	case aiEditorFinishedMsg:
+		m.applyEditedMarkdown(msg)
+		return m, nil
 	case tea.KeyMsg:
+		if m.isReviewing {
+			return m.updateReview(msg)
+		}
 		switch msg.String() {
-		case "ctrl+c", "q":
+		case "ctrl+c", "q", "esc":
+			m.cancel()
 			return m, tea.Quit
+		case "r":
+			if m.err != nil {
+				m.status = ""
+				return m, m.startRequest()
+			}
 		}
 	}
 
-	if isAiGenCompleted {
-		return m, tea.Quit
-	}
-
 	return m, tea.Batch(cmds...)
 }
 
@@ -221,110 +412,32 @@ var aiCommandGenerationModelPromtLipgloss = lipgloss.NewStyle().
 	Padding(0, 1)
 
 func (m aiCommandGenerationModel) View() string {
-	m.markdown.Viewport.SetContent(currentDisplayMarkdown)
+	m.markdown.Viewport.SetContent(m.rendered)
 	var view string = "\n\n"
 	// Print the view inside of a lipgloss container
 	view += aiCommandGenerationModelPromtLipgloss.Render(m.prompt)
+	if m.status != "" && !m.isReviewing {
+		view += " " + m.status
+	}
 	view += "\n"
 	view += m.markdown.View()
+	if m.isReviewing {
+		view += "\n" + m.reviewView()
+	} else if m.err != nil {
+		view += "\n" + execErrorStyle.Render("✗ "+m.err.Error()) + "\n" + "r retry • q quit"
+	}
 	return view
 }
 
-func markdownToCommand(contents string) Command {
-	lines := strings.Split(contents, "\n")
-	// Read until "##"
-	var title string
-	var isLookingForCodeblock bool
-	var codeBlockContent string
-	var description string
-	var isLookingForCodeblockEnd bool
-	var metadata map[string]map[string]string = make(map[string]map[string]string)
-	var markdown string
-	for _, line := range lines {
-		if strings.HasPrefix(line, "### ") {
-			title = strings.TrimPrefix(line, "### ")
-			description = ""
-			isLookingForCodeblock = true
-			markdown = ""
-			metadata = make(map[string]map[string]string)
-		} else if isLookingForCodeblock && !strings.HasPrefix(line, "```") {
-			description += line + "\n"
-		} else if isLookingForCodeblock && strings.HasPrefix(line, "```") {
-			isLookingForCodeblock = false
-			isLookingForCodeblockEnd = true
-			codeBlockContent = ""
-		} else if isLookingForCodeblockEnd && strings.HasPrefix(line, "```") {
-			isLookingForCodeblockEnd = false
-		} else if isLookingForCodeblockEnd {
-			codeBlockContent += line + "\n"
-		}
-
-		if strings.HasPrefix(line, "[") && strings.Contains(line, "]: <> (") {
-			// [key]: <> (value)
-			// The line looks like above, extract the key and the value
-			re := regexp.MustCompile(`^\[(.*)\]: <> \((.*)\)$`)
-			matches := re.FindStringSubmatch(line)
-			if len(matches) == 3 {
-				// Parse the value
-				var value string = matches[2]
-				// The value looks like: key1=value1 key2="value2"
-				// Extract all keys and values
-				value_re := regexp.MustCompile(`([A-Za-z0-9_]+)=(([^\s"]+)|("[^"]+"))`)
-				value_matches := value_re.FindAllStringSubmatch(value, -1)
-				if len(value_matches) > 0 {
-					metadata[matches[1]] = make(map[string]string)
-					for _, match := range value_matches {
-						metadata[matches[1]][match[1]] = strings.Trim(match[2], "\"")
-					}
-				}
-			}
-		} else {
-			markdown += line + "\n"
-		}
-	}
-
-	// Extract the names of the variables inside of {}, <>
-	codeBlockLines := strings.Split(codeBlockContent, "\n")
-	var variables []string
-	for _, codeBlockLine := range codeBlockLines {
-		// Find all regex matches
-		reVariableNameRegex := regexp.MustCompile("[{<]([A-Za-z\\d\\-_\\/]+)[>}]")
-		matches := reVariableNameRegex.FindAllStringSubmatch(codeBlockLine, -1)
-		for _, match := range matches {
-			variables = append(variables, match[1])
-		}
-	}
-
-	// Trim the last "\n" from the codeBlockContent and description
-	codeBlockContent = strings.TrimSuffix(codeBlockContent, "\n")
-	description = strings.TrimSuffix(description, "\n")
-
-	// Write the markdown file
-	markdownFilePath := filepath.Join(os.TempDir(), "cwc-ai-"+title+".md")
-	err := os.MkdirAll(filepath.Dir(markdownFilePath), 0744)
-	if err != nil {
-		log.Fatal("Failed to create markdown file parent directories", "error", err)
-	}
-	markdownFile, err := os.Create(markdownFilePath)
-	if err != nil {
-		log.Fatal("Failed to create markdown file", "error", err)
-	}
-	_, err = markdownFile.WriteString(markdown)
-	if err != nil {
-		log.Fatal("Failed to write markdown content", "error", err)
-	}
-	err = markdownFile.Close()
-	if err != nil {
-		log.Fatal("Failed to close markdown file", "error", err)
-	}
+// aiCommandPath returns the path the ids of generated commands are relative to, they are stored in the "ai" directory
+func aiCommandPath(path string) string {
+	return filepath.Join("ai", filepath.Base(path))
+}
 
-	// Write the command to the index serialized as json
-	return Command{
-		CmdTitle:       title,
-		Content:        codeBlockContent,
-		Variables:      variables,
-		CmdDescription: description,
-		MarkdownFile:   markdownFilePath,
-		Metadata:       metadata,
+func markdownToCommand(contents string) Command {
+	commands := parseCommands(contents, true)
+	if len(commands) == 0 {
+		return Command{AiGenerated: true}
 	}
+	return commands[len(commands)-1]
 }
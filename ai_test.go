@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// reviewAcceptingModel presses "a" once the generated command is shown for review, and quits if the request fails
+type reviewAcceptingModel struct {
+	model      aiCommandGenerationModel
+	isAccepted bool
+}
+
+func (m reviewAcceptingModel) Init() tea.Cmd { return m.model.Init() }
+
+func (m reviewAcceptingModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	next, cmd := m.model.Update(msg)
+	m.model = next.(aiCommandGenerationModel)
+	if m.model.err != nil {
+		return m, tea.Quit
+	}
+	if m.model.isReviewing && !m.isAccepted {
+		m.isAccepted = true
+		accept := func() tea.Msg { return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")} }
+		return m, tea.Batch(cmd, accept)
+	}
+	return m, cmd
+}
+
+func (m reviewAcceptingModel) View() string { return m.model.View() }
+
+// setupTestConfig points the config directory to a temporary directory with the config lines and an empty index
+func setupTestConfig(t *testing.T, config string) string {
+	configHome := t.TempDir()
+	t.Setenv("CWC_CONFIG_DIR", configHome)
+	configPath := filepath.Join(configHome, "commands-wiki", "config")
+	err := os.MkdirAll(filepath.Dir(configPath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(configPath, []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexFilePath, err := getIndexFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Dir(indexFilePath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeIndexFile(indexFilePath, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configHome
+}
+
+func TestRunCommandAiCommandGenerationReplay(t *testing.T) {
+	fixtures, err := filepath.Abs(filepath.Join("testdata", "ai-replay"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configHome := setupTestConfig(t, "repo https://github.com/lerndmina/commands-wiki\nai-provider fake\n")
+	t.Setenv("CWC_AI_REPLAY", fixtures)
+	t.Setenv("CWC_AI_RECORD", "")
+
+	// The prompt of the fixture is sent without commands of the index or the environment of this machine
+	previousRelevantCommandsFor, previousEnvironmentContext := relevantCommandsFor, environmentContext
+	t.Cleanup(func() { relevantCommandsFor, environmentContext = previousRelevantCommandsFor, previousEnvironmentContext })
+	relevantCommandsFor = func(string) []scoredCommand { return nil }
+	environmentContext = func() string { return "" }
+
+	// Accept the generated command and close the command that is shown afterwards
+	var shownCommand *cmdInfoModel
+	previousRunProgram := runProgram
+	t.Cleanup(func() { runProgram = previousRunProgram })
+	runProgram = func(model tea.Model) (tea.Model, error) {
+		switch model := model.(type) {
+		case aiCommandGenerationModel:
+			result, err := tea.NewProgram(reviewAcceptingModel{model: model}, tea.WithInput(nil), tea.WithOutput(io.Discard)).Run()
+			if err != nil {
+				return nil, err
+			}
+			return result.(reviewAcceptingModel).model, nil
+		case cmdInfoModel:
+			shownCommand = &model
+			return model, nil
+		}
+		t.Fatalf("unexpected program %T", model)
+		return nil, nil
+	}
+
+	runCommandAiCommandGeneration("show the disk usage of a directory")
+
+	if shownCommand == nil {
+		t.Fatal("the saved command was not shown")
+	}
+	if shownCommand.command.CmdTitle != "Show the disk usage of a directory" {
+		t.Errorf("shown command = %q, want the replayed one", shownCommand.command.CmdTitle)
+	}
+	saved, err := filepath.Glob(filepath.Join(configHome, "commands-wiki", "ai", "ai-*.md"))
+	if err != nil || len(saved) != 1 {
+		t.Fatalf("saved command files = %v (%v), want 1", saved, err)
+	}
+	contents, err := os.ReadFile(saved[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "du -sh <directory>") {
+		t.Errorf("saved command = %q, want the replayed response", contents)
+	}
+	commands, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 1 || !commands[0].AiGenerated || commands[0].SourceFile != saved[0] {
+		t.Errorf("index = %+v, want the generated command", commands)
+	}
+}
+
+// flakyProvider fails with the errors first and then answers like the fakeProvider
+type flakyProvider struct {
+	fakeProvider
+	failures []error
+	calls    *int
+}
+
+func (p flakyProvider) CreateCompletionStream(ctx context.Context, req completionRequest) (completionStream, error) {
+	*p.calls++
+	if *p.calls <= len(p.failures) {
+		return nil, p.failures[*p.calls-1]
+	}
+	return p.fakeProvider.CreateCompletionStream(ctx, req)
+}
+
+// hangingProvider never responds, its stream only returns when the request is cancelled or times out
+type hangingProvider struct {
+	fakeProvider
+}
+
+type hangingStream struct {
+	ctx context.Context
+}
+
+func (p hangingProvider) CreateCompletionStream(ctx context.Context, req completionRequest) (completionStream, error) {
+	return hangingStream{ctx: ctx}, nil
+}
+
+func (s hangingStream) Recv() (string, error) {
+	<-s.ctx.Done()
+	return "", s.ctx.Err()
+}
+
+func (s hangingStream) Close() error { return nil }
+
+// collectCompletion returns all messages of the request until the channel is closed
+func collectCompletion(t *testing.T, events chan tea.Msg) []tea.Msg {
+	t.Helper()
+	var msgs []tea.Msg
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		case <-timeout:
+			t.Fatalf("the request did not finish, received %v", msgs)
+			return nil
+		}
+	}
+}
+
+func TestStreamCompletion(t *testing.T) {
+	previousDelay := completionRetryDelay
+	completionRetryDelay = time.Millisecond
+	t.Cleanup(func() { completionRetryDelay = previousDelay })
+	rateLimited := &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "rate limited"}
+	badRequest := &openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "bad request"}
+
+	tests := []struct {
+		name      string
+		config    string
+		failures  []error
+		wantCalls int
+		wantRetry int
+		wantErr   error
+	}{
+		{name: "success", wantCalls: 1},
+		{name: "retried until it succeeds", failures: []error{rateLimited, rateLimited}, wantCalls: 3, wantRetry: 2},
+		{name: "retries exhausted", config: "ai-retries 1\n", failures: []error{rateLimited, rateLimited}, wantCalls: 2, wantRetry: 1, wantErr: rateLimited},
+		{name: "not retryable", failures: []error{badRequest}, wantCalls: 1, wantErr: badRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestConfig(t, "repo https://github.com/lerndmina/commands-wiki\n"+test.config)
+			calls := 0
+			provider := flakyProvider{fakeProvider: fakeProvider{response: "### Title\nline\n"}, failures: test.failures, calls: &calls}
+
+			msgs := collectCompletion(t, streamCompletion(context.Background(), provider, nil))
+
+			var generated string
+			var retries int
+			var gotErr error
+			var done bool
+			for _, msg := range msgs {
+				switch msg := msg.(type) {
+				case aiChunkMsg:
+					generated += string(msg)
+				case aiRetryMsg:
+					retries++
+					if msg.attempt != retries || msg.delay != completionRetryDelay<<(retries-1) {
+						t.Errorf("retry %d = %+v, want attempt %d with a doubled delay", retries, msg, retries)
+					}
+				case aiErrorMsg:
+					gotErr = msg.err
+				case aiDoneMsg:
+					done = true
+				}
+			}
+			if calls != test.wantCalls {
+				t.Errorf("requests = %d, want %d", calls, test.wantCalls)
+			}
+			if retries != test.wantRetry {
+				t.Errorf("retries = %d, want %d", retries, test.wantRetry)
+			}
+			if !errors.Is(gotErr, test.wantErr) {
+				t.Errorf("error = %v, want %v", gotErr, test.wantErr)
+			}
+			if test.wantErr == nil && (!done || generated != "### Title\nline\n") {
+				t.Errorf("response = %q (done %v), want the complete response", generated, done)
+			}
+		})
+	}
+}
+
+func TestAnthropicStreamRecv(t *testing.T) {
+	events := "event: message_start\ndata: {\"type\":\"message_start\"}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"text\":\"### Title\\n\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"text\":\"line\\n\"}}\n\n"
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+	}{
+		{name: "complete", body: events + "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n", wantErr: io.EOF},
+		{name: "truncated", body: events, wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := io.NopCloser(strings.NewReader(test.body))
+			cachePath := filepath.Join(t.TempDir(), "response.json")
+			stream := &recordingStream{stream: &anthropicStream{body: body, scanner: bufio.NewScanner(body)}, path: cachePath}
+			var generated string
+			var err error
+			for {
+				var chunk string
+				chunk, err = stream.Recv()
+				if err != nil {
+					break
+				}
+				generated += chunk
+			}
+			if err != test.wantErr {
+				t.Errorf("error = %v, want %v", err, test.wantErr)
+			}
+			if generated != "### Title\nline\n" {
+				t.Errorf("response = %q, want %q", generated, "### Title\nline\n")
+			}
+			// Only complete responses are cached
+			_, statErr := os.Stat(cachePath)
+			if isCached := statErr == nil; isCached != (test.wantErr == io.EOF) {
+				t.Errorf("cached = %v, want %v", isCached, test.wantErr == io.EOF)
+			}
+		})
+	}
+}
+
+func TestStreamCompletionCancel(t *testing.T) {
+	setupTestConfig(t, "repo https://github.com/lerndmina/commands-wiki\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	events := streamCompletion(ctx, hangingProvider{}, nil)
+	cancel()
+
+	// Cancelling is not an error, the user quit
+	for _, msg := range collectCompletion(t, events) {
+		if _, ok := msg.(aiErrorMsg); ok {
+			t.Errorf("got %v after cancelling, want no error", msg)
+		}
+	}
+}
+
+func TestStreamCompletionTimeout(t *testing.T) {
+	setupTestConfig(t, "repo https://github.com/lerndmina/commands-wiki\nai-timeout 1\n")
+
+	msgs := collectCompletion(t, streamCompletion(context.Background(), hangingProvider{}, nil))
+
+	if len(msgs) != 1 {
+		t.Fatalf("got %v, want a single error", msgs)
+	}
+	errMsg, ok := msgs[0].(aiErrorMsg)
+	if !ok || !errors.Is(errMsg.err, context.DeadlineExceeded) || !strings.Contains(errMsg.err.Error(), "increase ai-timeout") {
+		t.Errorf("got %v, want the timeout error", msgs[0])
+	}
+}
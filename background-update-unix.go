@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// detachedProcessAttributes starts the process in a new session so it keeps running after cwc exits and does
+// not get the signals of the terminal
+func detachedProcessAttributes() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const (
+	createNewProcessGroup = 0x00000200
+	detachedProcess       = 0x00000008
+)
+
+// detachedProcessAttributes starts the process without a console so it keeps running after cwc exits
+func detachedProcessAttributes() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: createNewProcessGroup | detachedProcess}
+}
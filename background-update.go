@@ -0,0 +1,131 @@
+package main
+
+// This file contains the background refresh of the index, when the index is older than git-update-interval a
+// detached "cwc update" is started so searching never waits for the network. The output of the update is
+// written to update.log in the config directory.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// updateRetryInterval is how long to wait before starting another background update
+const updateRetryInterval = 10 * time.Minute
+
+func getUpdateLockPath() (string, error) {
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	repo_name, err := GetRepoName()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, "commands-wiki", "repos", repo_name+".lock"), nil
+}
+
+// acquireUpdateLock makes sure only one update of the repository runs at a time, call the returned function to
+// release the lock
+func acquireUpdateLock() (func(), error) {
+	lockPath, err := getUpdateLockPath()
+	if err != nil {
+		return nil, err
+	}
+	releaseLock, err := acquireLockFile(lockPath, 0)
+	if err != nil {
+		return nil, fmt.Errorf("another update is already running: %w", err)
+	}
+	return releaseLock, nil
+}
+
+func isUpdateRunning() bool {
+	lockPath, err := getUpdateLockPath()
+	if err != nil {
+		return false
+	}
+	return isLockFileHeld(lockPath)
+}
+
+// startBackgroundUpdate starts "cwc update" as a detached process
+func startBackgroundUpdate() error {
+	if isUpdateRunning() {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		return err
+	}
+	// Do not try again right away when the last update failed, for example because we are offline
+	logPath := filepath.Join(configPath, "commands-wiki", "update.log")
+	if info, err := os.Stat(logPath); err == nil && time.Since(info.ModTime()) < updateRetryInterval {
+		return nil
+	}
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, "update")
+	// The update runs without a terminal, so it must not ask to update cwc itself
+	cmd.Env = append(os.Environ(), "CWC_BACKGROUND=1")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcessAttributes()
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// checkIfUpdateNeeded starts a background update if the index is older than git-update-interval
+func checkIfUpdateNeeded() {
+	lastUpdate, err := getLastIndexUpdate()
+	if err != nil {
+		return
+	}
+
+	// Get the update interval from the config
+	timeout, err := strconv.ParseUint(GetValueNoError("git-update-interval", "86400000"), 10, 64)
+	if err != nil {
+		timeout = 86400000
+	}
+	if uint64(time.Now().UnixMilli())-lastUpdate > timeout {
+		startBackgroundUpdate()
+	}
+}
+
+// indexCheckMsg is sent every few seconds while searching, isUpdated is true once the index has been rewritten
+type indexCheckMsg struct {
+	isUpdated bool
+}
+
+func getIndexModTime() time.Time {
+	indexFilePath, err := getIndexFilePath()
+	if err != nil {
+		return time.Time{}
+	}
+	info, err := os.Stat(indexFilePath)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// watchIndexUpdate checks if the index changed since it was read at indexModTime
+func watchIndexUpdate(indexModTime time.Time) tea.Cmd {
+	return tea.Tick(2*time.Second, func(time.Time) tea.Msg {
+		return indexCheckMsg{isUpdated: !getIndexModTime().Equal(indexModTime)}
+	})
+}
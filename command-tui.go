@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
@@ -30,6 +35,17 @@ type cmdInfoModel struct {
 	validationData       string
 	textInput            textinput.Model
 	currentVariableInput string
+	secretErrors         map[string]error
+	execError            error
+	isSavingToFile       bool
+	isConfirming         bool
+	isConfirmed          bool
+	dangerReason         string
+	host                 string
+	isPickingHost        bool
+	hostChoices          []string
+	hostCursor           int
+	previousVariables    map[string]string
 }
 
 type cmdInfoKeymap struct {
@@ -38,12 +54,13 @@ type cmdInfoKeymap struct {
 	Execute key.Binding
 	Quit    key.Binding
 	Help    key.Binding
+	Host    key.Binding
 }
 
 // ShortHelp returns keybindings to be shown in the mini help view. It's part
 // of the key.Map interface.
 func (k cmdInfoKeymap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Execute, k.Help, k.Quit}
+	return []key.Binding{k.Execute, k.Host, k.Help, k.Quit}
 }
 
 // FullHelp returns keybindings for the expanded help view. It's part of the
@@ -51,10 +68,12 @@ func (k cmdInfoKeymap) ShortHelp() []key.Binding {
 func (k cmdInfoKeymap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down, k.Execute}, // first column
-		{k.Help, k.Quit},          // second column
+		{k.Host, k.Help, k.Quit},  // second column
 	}
 }
 
+var execErrorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F5F"))
+
 var DefaultKeyMap = cmdInfoKeymap{
 	Execute: key.NewBinding(
 		key.WithKeys("enter"),
@@ -76,6 +95,10 @@ var DefaultKeyMap = cmdInfoKeymap{
 		key.WithKeys("?"),
 		key.WithHelp("?", "toggle help"),
 	),
+	Host: key.NewBinding(
+		key.WithKeys("h"),
+		key.WithHelp("h", "choose host"),
+	),
 }
 
 type cmdInfoKeymapVariables struct {
@@ -110,7 +133,6 @@ func (k cmdInfoKeymapVariables) FullHelp() [][]key.Binding {
 
 func newCmdInfoModel(cmd Command) cmdInfoModel {
 	markdownModel := markdown.New(true, true, lipgloss.AdaptiveColor{Light: "#000000", Dark: "#ffffff"})
-	markdownModel.FileName = cmd.MarkdownFile
 
 	ti := textinput.New()
 	ti.Placeholder = ""
@@ -125,9 +147,11 @@ func newCmdInfoModel(cmd Command) cmdInfoModel {
 		help:                 help.New(),
 		command:              cmd,
 		variables:            make(map[string]string),
+		secretErrors:         make(map[string]error),
 		isReadingVariables:   false,
 		currentVariableInput: "",
 		textInput:            ti,
+		host:                 targetHost,
 	}
 }
 
@@ -146,17 +170,30 @@ func (m cmdInfoModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		cmds = append(cmds, m.markdown.SetSize(msg.Width, msg.Height))
+		// The markdown is stored in the index, render it for the new width
+		render, err := markdown.RenderMarkdown(msg.Width, m.command.Markdown)
+		if err == nil {
+			m.markdown.Viewport.SetContent(render)
+		}
 
 		return m, tea.Batch(cmds...)
 	case tea.KeyMsg:
-		if m.isReadingVariables && !key.Matches(msg, m.keys.Execute) && msg.Type != tea.KeyCtrlC {
+		if m.isPickingHost {
+			return m.updateHostPicker(msg)
+		}
+		if m.isConfirming && msg.Type == tea.KeyEsc {
+			// Go back to the command instead of running it
+			m.isConfirming = false
+			return m, nil
+		}
+		if m.isTyping() && !key.Matches(msg, m.keys.Execute) && msg.Type != tea.KeyCtrlC {
 			m.textInput, cmd = m.textInput.Update(msg)
 			cmds = append(cmds, cmd)
 		}
 		if msg.Type == tea.KeyCtrlC {
 			return m, tea.Quit
 		}
-		if !m.isReadingVariables {
+		if !m.isTyping() {
 			switch msg.String() {
 			case "ctrl+c", "esc", "q":
 				cmds = append(cmds, tea.Quit)
@@ -164,25 +201,47 @@ func (m cmdInfoModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		switch {
 		case key.Matches(msg, m.keys.Execute):
-			if m.isReadingVariables {
+			if m.isConfirming {
+				m.confirm(&cmds)
+			} else if m.isSavingToFile {
+				m.saveToFile(&cmds)
+			} else if m.isReadingVariables {
 				setVariable(&m, &cmds)
-				m = updateVariableMetadata(m)
+				if m.isReadingVariables {
+					m = updateVariableMetadata(m)
+				}
 			} else if len(m.command.Variables) > 0 {
-				// Ask for the variables
-				m.isReadingVariables = true
-				m.currentVariableInput = m.command.Variables[0]
-				m.validationRegex = nil
-				m.validationType = ""
-				m.validationData = ""
-				m = updateVariableMetadata(m)
+				// Secrets with a source do not need to be typed in
+				m.resolveSecretSources()
+				variable, hasMissingVar := m.nextMissingVariable()
+				if hasMissingVar {
+					// Ask for the variables
+					m.isReadingVariables = true
+					m.currentVariableInput = variable
+					m.validationRegex = nil
+					m.validationType = ""
+					m.validationData = ""
+					m = updateVariableMetadata(m)
+				} else {
+					m.runCommand(&cmds)
+				}
 			} else {
 				// Run the command
-				generateExecCommand(m.command, m.variables)
-				cmds = append(cmds, tea.Quit)
+				m.runCommand(&cmds)
+			}
+		case key.Matches(msg, m.keys.Host) && !m.isTyping():
+			m.isPickingHost = true
+			m.hostChoices = append([]string{"local"}, readSshHosts()...)
+			m.hostCursor = 0
+			for i, host := range m.hostChoices {
+				if host == m.host {
+					m.hostCursor = i
+				}
 			}
+			return m, nil
 		case key.Matches(msg, m.keys.Help):
 			m.help.ShowAll = !m.help.ShowAll
-		case key.Matches(msg, m.keys.Quit) && !m.isReadingVariables:
+		case key.Matches(msg, m.keys.Quit) && !m.isTyping():
 			return m, tea.Quit
 		}
 	}
@@ -193,6 +252,28 @@ func (m cmdInfoModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// updateHostPicker handles the key presses whilst the user is choosing the host to run the command on
+func (m cmdInfoModel) updateHostPicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.Type == tea.KeyCtrlC:
+		return m, tea.Quit
+	case key.Matches(msg, m.keys.Up):
+		if m.hostCursor > 0 {
+			m.hostCursor--
+		}
+	case key.Matches(msg, m.keys.Down):
+		if m.hostCursor < len(m.hostChoices)-1 {
+			m.hostCursor++
+		}
+	case key.Matches(msg, m.keys.Execute):
+		m.host = m.hostChoices[m.hostCursor]
+		m.isPickingHost = false
+	case key.Matches(msg, m.keys.Quit):
+		m.isPickingHost = false
+	}
+	return m, nil
+}
+
 func updateVariableMetadata(m cmdInfoModel) cmdInfoModel {
 	commandMetadata := m.command.Metadata
 	// Get the variable metadata
@@ -225,11 +306,15 @@ func updateVariableMetadata(m cmdInfoModel) cmdInfoModel {
 	variablePlaceholder := variableMetadata["placeholder"]
 	m.textInput.Placeholder = variablePlaceholder
 
-	variableType := variableMetadata["type"]
-	if variableType == "" {
+	// When the command is edited after it ran, start with the value that was used last time
+	if previousValue, ok := m.previousVariables[m.currentVariableInput]; ok {
+		m.textInput.SetValue(previousValue)
+	}
+
+	if !isSecretVariable(variableMetadata) {
 		m.textInput.EchoMode = textinput.EchoNormal
 		m.textInput.EchoCharacter = ' '
-	} else if variableType == "password" {
+	} else {
 		m.textInput.EchoMode = textinput.EchoPassword
 		m.textInput.EchoCharacter = '•'
 	}
@@ -245,59 +330,294 @@ func setVariable(m *cmdInfoModel, cmds *[]tea.Cmd) {
 	(*m).textInput.SetValue("")
 
 	// Check if all variables have been read
-	var hasMissingVar bool
-	for _, variable := range (*m).command.Variables {
-		if _, ok := (*m).variables[variable]; !ok {
-			// Ask for this variable
-			(*m).currentVariableInput = variable
-			hasMissingVar = true
+	variable, hasMissingVar := m.nextMissingVariable()
+	if hasMissingVar {
+		// Ask for this variable
+		(*m).currentVariableInput = variable
+	} else {
+		// Run the command
+		m.runCommand(cmds)
+	}
+}
+
+// runCommand generates the script for the command and quits the UI so that showCommmand can execute it
+// If the script can not be generated the error is shown in the UI instead
+func (m *cmdInfoModel) runCommand(cmds *[]tea.Cmd) {
+	if _, runnable := interpreterFor(m.command.Language); !runnable {
+		// Languages like yaml can not be executed, ask where to write the command to instead
+		m.isReadingVariables = false
+		m.isSavingToFile = true
+		m.textInput.SetValue("")
+		m.textInput.Placeholder = "command." + m.command.Language
+		m.textInput.EchoMode = textinput.EchoNormal
+		return
+	}
+	if reason, dangerous := dangerReason(m.command, m.displayedCommand()); dangerous && !m.isConfirmed {
+		// Destructive commands have to be confirmed before they run
+		m.isReadingVariables = false
+		m.isConfirming = true
+		m.dangerReason = reason
+		m.textInput.SetValue("")
+		m.textInput.Placeholder = "yes"
+		m.textInput.EchoMode = textinput.EchoNormal
+		return
+	}
+	err := generateExecCommand(m.command, m.variables)
+	if err != nil {
+		m.execError = err
+		return
+	}
+	*cmds = append(*cmds, tea.Quit)
+}
+
+// confirm runs the command if the user typed "yes" or the title of the command
+func (m *cmdInfoModel) confirm(cmds *[]tea.Cmd) {
+	value := strings.TrimSpace(m.textInput.Value())
+	if value != "yes" && value != m.command.CmdTitle {
+		m.execError = fmt.Errorf("type \"yes\" or the title of the command to run it, esc to go back")
+		return
+	}
+	m.isConfirming = false
+	m.isConfirmed = true
+	m.execError = nil
+	m.runCommand(cmds)
+}
+
+// displayedCommand returns the command with the variables replaced, secrets are shown as environment variables
+func (m cmdInfoModel) displayedCommand() string {
+	interpreter, _ := interpreterFor(m.command.Language)
+	if interpreter.SecretReference == "" {
+		interpreter = defaultInterpreters["bash"]
+	}
+	// Secrets that can not be referenced are still shown as a reference, running the command fails instead
+	content, _ := substituteVariables(m.command, m.variables, interpreter)
+	return content
+}
+
+// saveToFile writes the command with the variables replaced to the file the user entered, secrets are written as a
+// reference to their environment variable or as their placeholder
+func (m *cmdInfoModel) saveToFile(cmds *[]tea.Cmd) {
+	filePath := m.textInput.Value()
+	if filePath == "" {
+		filePath = m.textInput.Placeholder
+	}
+	interpreter, _ := interpreterFor(m.command.Language)
+	content, err := substituteVariables(m.command, m.variables, interpreter)
+	if err != nil {
+		m.execError = err
+		return
+	}
+	err = os.WriteFile(filePath, []byte(content), 0600)
+	if err != nil {
+		m.execError = err
+		return
+	}
+	savedFileOnExit = &filePath
+	*cmds = append(*cmds, tea.Quit)
+}
+
+// isTyping returns true if key presses should go to the text input
+func (m cmdInfoModel) isTyping() bool {
+	return m.isReadingVariables || m.isSavingToFile || m.isConfirming
+}
+
+// nextMissingVariable returns the first variable that does not have a value yet
+func (m cmdInfoModel) nextMissingVariable() (string, bool) {
+	for _, variable := range m.command.Variables {
+		if _, ok := m.variables[variable]; !ok {
+			return variable, true
 		}
 	}
-	if !hasMissingVar {
-		// Run the command
-		generateExecCommand((*m).command, (*m).variables)
-		*cmds = append(*cmds, tea.Quit)
+	return "", false
+}
+
+// resolveSecretSources reads all secret variables that have a source from their source
+// If a secret can not be read the user will be asked for it instead
+func (m *cmdInfoModel) resolveSecretSources() {
+	for _, variable := range m.command.Variables {
+		variableMetadata := m.command.Metadata[variable]
+		if !isSecretVariable(variableMetadata) || variableMetadata["source"] == "" {
+			continue
+		}
+		if _, ok := m.variables[variable]; ok {
+			continue
+		}
+		value, err := resolveSecret(variableMetadata["source"])
+		if err != nil {
+			m.secretErrors[variable] = err
+			continue
+		}
+		m.variables[variable] = value
 	}
 }
 
 var fileToExecuteOnExit *string
 var bashFileContent *string
+var secretEnvOnExit []string
+var interpreterOnExit []string
+var savedFileOnExit *string
+
+// substituteVariables replaces the variables in the content of the command with their values
+// Secret variables are replaced with a reference to their environment variable of the interpreter, an error is
+// returned if a secret is inside of quotes where the reference would not be read. If the interpreter has no secret
+// reference the placeholder of the secret is kept.
+func substituteVariables(cmd Command, variables map[string]string, interp interpreter) (string, error) {
+	content := cmd.Content + "\n"
+	escape := byte('\\')
+	if interp.SecretQuoting == quotingDoubleQuotes {
+		escape = '`'
+	}
+	var newContent strings.Builder
+	var quote byte
+	var isComment bool
+	var secretErr error
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if variable, length, ok := placeholderAt(content[i:], variables); ok {
+			value := variables[variable]
+			if interp.SecretReference == "" && isSecretVariable(cmd.Metadata[variable]) {
+				value = content[i : i+length]
+			} else if isSecretVariable(cmd.Metadata[variable]) {
+				var err error
+				value, err = secretReferenceIn(interp, variable, quote, isComment)
+				if err != nil && secretErr == nil {
+					secretErr = err
+				}
+			}
+			newContent.WriteString(value)
+			i += length - 1
+			continue
+		}
+		newContent.WriteByte(c)
+		switch {
+		case c == '\n':
+			isComment = false
+			if interp.SecretQuoting != quotingShell && quote != '`' {
+				// Strings of most languages end at the end of the line, this keeps a stray quote from spreading
+				quote = 0
+			}
+		case isComment:
+		case c == escape && i+1 < len(content) && !(quote == '\'' && interp.SecretQuoting == quotingShell):
+			i++
+			newContent.WriteByte(content[i])
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || (c == '`' && interp.SecretQuoting == quotingExpression):
+			quote = c
+		case c == '#' && (i == 0 || content[i-1] == ' ' || content[i-1] == '\t' || content[i-1] == '\n'):
+			isComment = true
+		}
+	}
+	return newContent.String(), secretErr
+}
+
+// placeholderAt returns the variable if the content starts with one of its placeholders, like {name} or <name>,
+// and the length of the placeholder
+func placeholderAt(content string, variables map[string]string) (string, int, bool) {
+	if len(content) == 0 || (content[0] != '{' && content[0] != '<') {
+		return "", 0, false
+	}
+	closing := "}"
+	if content[0] == '<' {
+		closing = ">"
+	}
+	end := strings.Index(content, closing)
+	if end < 0 {
+		return "", 0, false
+	}
+	variable := content[1:end]
+	if _, ok := variables[variable]; !ok {
+		return "", 0, false
+	}
+	return variable, end + 1, true
+}
 
-func generateExecCommand(cmd Command, variables map[string]string) {
+func generateExecCommand(cmd Command, variables map[string]string) error {
+	interpreter, runnable := interpreterFor(cmd.Language)
+	if !runnable {
+		return fmt.Errorf("%s commands can not be executed", cmd.Language)
+	}
 
-	// Replace the variables in the content
-	var newContent string
-	for _, line := range strings.Split(cmd.Content, "\n") {
-		for variable, value := range variables {
-			line = strings.ReplaceAll(line, "{"+variable+"}", value)
-			line = strings.ReplaceAll(line, "<"+variable+">", value)
+	// Secrets are passed to the script through the environment so they are never written to disk
+	secretEnvOnExit = nil
+	for variable := range variables {
+		if isSecretVariable(cmd.Metadata[variable]) && interpreter.SecretReference == "" {
+			return fmt.Errorf("%s commands can not be executed with secrets as %s can not be read from the environment", cmd.Language, variable)
 		}
-		newContent += line + "\n"
 	}
+	env, err := secretEnv(cmd, variables)
+	if err != nil {
+		return err
+	}
+	secretEnvOnExit = env
+
+	// Replace the variables in the content
+	newContent, err := substituteVariables(cmd, variables, interpreter)
+	if err != nil {
+		return err
+	}
+	interpreterOnExit = interpreter.Args
 
 	bashFileContent = &newContent
 
-	// Write the content to a bash file and run that files
-	tempDir := os.TempDir()
-	filePath := filepath.Join(tempDir, "cmdwiki-exec-"+cmd.CmdTitle+".sh")
-	file, err := os.Create(filePath)
+	// Write the content to a script in a private directory, only the current user can read it
+	scriptDir, err := os.MkdirTemp("", "cwc-exec-")
 	if err != nil {
-		return
+		return fmt.Errorf("failed to create the directory for the script: %w", err)
+	}
+	file, err := os.CreateTemp(scriptDir, "cmdwiki-exec-*"+interpreter.Extension)
+	if err != nil {
+		os.RemoveAll(scriptDir)
+		return fmt.Errorf("failed to create the script: %w", err)
 	}
-	defer file.Close()
 	_, err = file.WriteString(newContent)
 	if err != nil {
-		return
+		file.Close()
+		os.RemoveAll(scriptDir)
+		return fmt.Errorf("failed to write the script: %w", err)
 	}
 	err = file.Close()
 	if err != nil {
-		return
+		os.RemoveAll(scriptDir)
+		return fmt.Errorf("failed to write the script: %w", err)
 	}
-	err = os.Chmod(filePath, 0755)
+	err = os.Chmod(file.Name(), 0700)
 	if err != nil {
-		return
+		os.RemoveAll(scriptDir)
+		return fmt.Errorf("failed to make the script executable: %w", err)
 	}
+	filePath := file.Name()
 	fileToExecuteOnExit = &filePath
+	return nil
+}
+
+// removeScriptOnSignal makes sure the script directory is removed if cwc is terminated whilst the script runs
+// Interrupts are ignored by cwc as they are delivered to the running script as well, after which the script
+// exits and the directory is removed as usual. The returned function stops listening for signals.
+func removeScriptOnSignal(scriptDir string) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == os.Interrupt {
+					continue
+				}
+				os.RemoveAll(scriptDir)
+				os.Exit(1)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
 }
 
 var mimetypeCache map[string]string
@@ -354,6 +674,9 @@ func (m cmdInfoModel) GetValidationError() string {
 
 // View returns a string representation of the UI.
 func (m cmdInfoModel) View() string {
+	if m.isConfirming {
+		return m.confirmationView()
+	}
 	view := m.markdown.View()
 	if m.isReadingVariables {
 		var variableLines string
@@ -371,6 +694,9 @@ func (m cmdInfoModel) View() string {
 		if variableDescription != "" {
 			variableLines += "Description: " + variableDescription + "\n"
 		}
+		if err := m.secretErrors[m.currentVariableInput]; err != nil {
+			variableLines += "Could not read the secret (" + err.Error() + "), please enter it manually\n"
+		}
 
 		// Remove 4 lines from the from the bottom
 		lines := strings.Split(view, "\n")
@@ -391,35 +717,164 @@ func (m cmdInfoModel) View() string {
 
 		view += "\n\n"
 		view += m.help.View(m.variableKeys)
+	} else if m.isSavingToFile {
+		view += "\n\n"
+		view += m.command.Language + " can not be executed, enter the file to write it to: "
+		view += m.textInput.View()
+		view += "\n\n"
+		view += m.help.View(m.variableKeys)
+	} else if m.isPickingHost {
+		// Make room for the hosts at the bottom of the markdown
+		lines := strings.Split(view, "\n")
+		lines = lines[:max(0, len(lines)-len(m.hostChoices)-3)]
+		view = strings.Join(lines, "\n")
+		view += "\n\nRun the command on:\n"
+		for i, host := range m.hostChoices {
+			if i == m.hostCursor {
+				view += "> " + host + "\n"
+			} else {
+				view += "  " + host + "\n"
+			}
+		}
 	} else {
 		view += "\n\n"
+		if m.host != "" && m.host != "local" {
+			view += "Running on " + m.host + "\n"
+		}
 		view += m.help.View(m.keys)
 	}
+	if m.execError != nil {
+		view += "\n" + execErrorStyle.Render("Could not run the command: "+m.execError.Error())
+	}
 
 	return view
 }
 
-func showCommmand(cmd Command) {
-	b := newCmdInfoModel(cmd)
-	p := tea.NewProgram(b, tea.WithAltScreen())
+var confirmationStyle = lipgloss.NewStyle().
+	Border(lipgloss.ThickBorder()).
+	BorderForeground(lipgloss.Color("#FF5F5F")).
+	Padding(1, 2)
+
+var confirmationTitleStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#FFFDF5")).
+	Background(lipgloss.Color("#D70000")).
+	Padding(0, 1)
+
+// confirmationView shows the final command and asks the user to confirm that it should run
+func (m cmdInfoModel) confirmationView() string {
+	var content string
+	content += confirmationTitleStyle.Render("⚠ "+m.command.CmdTitle) + "\n\n"
+	content += execErrorStyle.Render("This command is dangerous: "+m.dangerReason) + "\n\n"
+	content += strings.TrimSuffix(m.displayedCommand(), "\n") + "\n\n"
+	if m.host != "" && m.host != "local" {
+		content += "It will run on " + m.host + "\n\n"
+	}
+	content += "Type \"yes\" or the title of the command to run it: " + m.textInput.View()
 
-	if _, err := p.Run(); err != nil {
-		log.Fatal(err)
+	view := "\n" + confirmationStyle.Render(content) + "\n"
+	if m.execError != nil {
+		view += "\n" + execErrorStyle.Render(m.execError.Error())
 	}
+	return view
+}
 
-	if fileToExecuteOnExit != nil {
+// runProgram runs the UI of the model in the alt screen, tests replace it to drive the UI without a terminal
+var runProgram = func(model tea.Model) (tea.Model, error) {
+	return tea.NewProgram(model, tea.WithAltScreen()).Run()
+}
 
-		// Run the bash script in the terminal
-		fmt.Println("Running command:")
-		fmt.Println(*bashFileContent)
-		fmt.Println("")
+func showCommmand(cmd Command) {
+	host := targetHost
+	previousVariables := make(map[string]string)
+	for {
+		fileToExecuteOnExit = nil
+		savedFileOnExit = nil
+
+		b := newCmdInfoModel(cmd)
+		b.host = host
+		b.previousVariables = previousVariables
+		model, err := runProgram(b)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if savedFileOnExit != nil {
+			fmt.Println("Wrote command to " + *savedFileOnExit)
+		}
+		if fileToExecuteOnExit == nil {
+			return
+		}
+		host = model.(cmdInfoModel).host
+		previousVariables = model.(cmdInfoModel).variables
 
-		execCommand("bash", []string{*fileToExecuteOnExit})
+		action := executeScript(cmd, host)
+		for action == outputActionRerun {
+			err = generateExecCommand(cmd, previousVariables)
+			if err != nil {
+				log.Error("failed to prepare the command", "error", err)
+				return
+			}
+			action = executeScript(cmd, host)
+		}
+		if action != outputActionEdit {
+			return
+		}
+	}
+}
 
-		// Remove the file
-		err := os.Remove(*fileToExecuteOnExit)
+// executeScript runs the script generated by generateExecCommand on the host and removes it afterwards
+// The returned action tells if the user wants to run the command again when the output pane is used
+func executeScript(cmd Command, host string) outputAction {
+	// Always remove the script, even if we panic or get terminated
+	scriptDir := filepath.Dir(*fileToExecuteOnExit)
+	defer os.RemoveAll(scriptDir)
+	stopSignals := removeScriptOnSignal(scriptDir)
+	defer stopSignals()
+
+	transport := newTransport(host)
+	process, err := transport.Command(interpreterOnExit, *fileToExecuteOnExit, secretEnvOnExit)
+	if err != nil {
+		log.Error("failed to prepare the command", "host", transport.Name(), "error", err)
+		return outputActionNone
+	}
+	shownContent := maskSecrets(*bashFileContent, secretEnvOnExit)
+
+	var action outputAction
+	var exitCode int
+	if outputMode == "pane" {
+		action, exitCode, err = runInOutputPane(cmd.CmdTitle, transport.Name(), process)
 		if err != nil {
-			log.Fatal(err)
+			// The deferred cleanup still removes the script
+			log.Error("failed to show the output pane", "error", err)
+			return outputActionNone
 		}
+	} else {
+		// Run the script in the terminal
+		if transport.Name() == "local" {
+			fmt.Println("Running command:")
+		} else {
+			fmt.Println("Running command on " + transport.Name() + ":")
+		}
+		fmt.Println(shownContent)
+		fmt.Println("")
+
+		err = runAttached(process)
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			// The interpreter or ssh could not be started, the exit code is -1
+			log.Error("failed to run the command", "host", transport.Name(), "error", err)
+		}
+		exitCode = process.ProcessState.ExitCode()
+	}
+
+	err = appendHistory(historyEntry{
+		Time:     time.Now(),
+		Title:    cmd.CmdTitle,
+		Host:     transport.Name(),
+		Command:  shownContent,
+		ExitCode: exitCode,
+	})
+	if err != nil {
+		log.Warn("failed to write the command history", "error", err)
 	}
+	return action
 }
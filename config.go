@@ -1,10 +1,10 @@
 package main
 
 // This file contains everything for reading the config from the users config directory
-// The config is a key value file with lines like "repo <value>"
+// The config is a key value file with lines like "repo <value>", the value is the rest of the line
 
 import (
-	"fmt"
+	"bufio"
 	"os"
 	"path/filepath"
 	"strings"
@@ -36,23 +36,29 @@ func readConfig(configPath string, config *Config) error {
 	}
 	defer file.Close()
 
-	var key string
-	var value string
-	for {
-		_, err := fmt.Fscanf(file, "%s %s\n", &key, &value)
-		if err != nil {
-			break
-		}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		value = strings.TrimSpace(value)
 		if key == "" || value == "" {
 			continue
 		}
 		(*config)[key] = value
 	}
-	return nil
+	return scanner.Err()
+}
+
+// getUserConfigDir returns the directory the commands-wiki directory is stored in, CWC_CONFIG_DIR overrides the
+// config directory of the user
+func getUserConfigDir() (string, error) {
+	if dir := os.Getenv("CWC_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	return os.UserConfigDir()
 }
 
 func CleanConfig() error {
-	configPath, err := os.UserConfigDir()
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		return err
 	}
@@ -63,7 +69,7 @@ func CleanConfig() error {
 // GetConfig returns the config from the users config directory
 func GetConfig() (Config, error) {
 	config := Config{}
-	configPath, err := os.UserConfigDir()
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		return config, err
 	}
@@ -106,9 +112,5 @@ func GetRepoName() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	split := strings.Split(repo, "/")
-	if len(split) < 2 {
-		return "", fmt.Errorf("repo name not found in repo url")
-	}
-	return split[len(split)-2] + "/" + split[len(split)-1], nil
+	return getRepoNameFor(repo)
 }
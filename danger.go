@@ -0,0 +1,66 @@
+package main
+
+// This file contains everything for detecting destructive commands that have to be confirmed before they run
+// A command can be marked as dangerous in the markdown with the "cwc" metadata key, for example:
+// [cwc]: <> (danger=true confirm="This wipes all data on the disk")
+// danger=false turns off the built-in detection for commands that are flagged by mistake.
+
+import (
+	"regexp"
+)
+
+// commandMetadataKey is the metadata key that holds settings for the command itself instead of a variable
+const commandMetadataKey = "cwc"
+
+var dangerousPatterns = []struct {
+	pattern *regexp.Regexp
+	reason  string
+}{
+	{regexp.MustCompile(`\brm\s+(-\S+\s+)*-[a-zA-Z]*([rR][a-zA-Z]*f|f[a-zA-Z]*[rR])`), "removes files recursively without asking"},
+	{regexp.MustCompile(`\brm(\s+-\S+)*\s+-[a-zA-Z]*[rR][a-zA-Z]*(\s+-\S+)*\s+-[a-zA-Z]*f`), "removes files recursively without asking"},
+	{regexp.MustCompile(`\brm(\s+-\S+)*\s+-[a-zA-Z]*f[a-zA-Z]*(\s+-\S+)*\s+-[a-zA-Z]*[rR]`), "removes files recursively without asking"},
+	{regexp.MustCompile(`\brm\s+.*(--recursive.*--force|--force.*--recursive)`), "removes files recursively without asking"},
+	{regexp.MustCompile(`\bdd\s+.*\bof=`), "overwrites a file or device with dd"},
+	{regexp.MustCompile(`\bmkfs(\.\w+)?\s`), "creates a new filesystem, erasing the device"},
+	{regexp.MustCompile(`\bwipefs\s`), "wipes filesystem signatures from a device"},
+	{regexp.MustCompile(`(?i)\bdrop\s+(table|database|schema)\b`), "drops database objects"},
+	{regexp.MustCompile(`(?i)\btruncate\s+table\b`), "deletes all rows of a table"},
+	{regexp.MustCompile(`\bgit\s+push\b.*\s(--force\S*|-[a-zA-Z]*f[a-zA-Z]*\b|\+\S)`), "force pushes, overwriting the remote history"},
+	{regexp.MustCompile(`\bkubectl\s+(\S+\s+)*delete\b`), "deletes kubernetes resources"},
+}
+
+// commandSeparator splits a script into its simple commands, so a pattern can not match across "&&", ";", "|", a
+// subshell or a new line
+var commandSeparator = regexp.MustCompile("&&|\\|\\||[;&|\n()`]|\\$\\(")
+
+// dangerReason returns why the command is dangerous, content is the command with the variables replaced.
+// The second return value is false if the command does not need to be confirmed.
+func dangerReason(cmd Command, content string) (string, bool) {
+	commandMetadata := cmd.Metadata[commandMetadataKey]
+	switch commandMetadata["danger"] {
+	case "true":
+		if commandMetadata["confirm"] != "" {
+			return commandMetadata["confirm"], true
+		}
+		return "the command is marked as dangerous", true
+	case "false":
+		return "", false
+	}
+
+	simpleCommands := commandSeparator.Split(content, -1)
+	for _, dangerous := range dangerousPatterns {
+		for _, simpleCommand := range simpleCommands {
+			if !dangerous.pattern.MatchString(simpleCommand) {
+				continue
+			}
+			if commandMetadata["confirm"] != "" {
+				return commandMetadata["confirm"], true
+			}
+			return dangerous.reason, true
+		}
+	}
+	if commandMetadata["confirm"] != "" {
+		return commandMetadata["confirm"], true
+	}
+	return "", false
+}
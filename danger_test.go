@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestDangerReason(t *testing.T) {
+	tests := []struct {
+		content     string
+		isDangerous bool
+	}{
+		{content: "rm -rf /tmp/build", isDangerous: true},
+		{content: "sudo rm -r -f /var/lib/thing", isDangerous: true},
+		{content: "rm --force --recursive build", isDangerous: true},
+		{content: "rm -i old.log", isDangerous: false},
+		{content: "dd if=image.iso of=/dev/sdb bs=4M", isDangerous: true},
+		{content: "mkfs.ext4 /dev/sdb1", isDangerous: true},
+		{content: "psql -c 'DROP TABLE users'", isDangerous: true},
+		{content: "git push --force origin main", isDangerous: true},
+		{content: "git push --force-with-lease", isDangerous: true},
+		{content: "git push -uf origin main", isDangerous: true},
+		{content: "git push origin +main", isDangerous: true},
+		{content: "git push origin +HEAD:main", isDangerous: true},
+		{content: "git push --follow-tags origin main", isDangerous: false},
+		{content: "git push origin main && git fetch -f", isDangerous: false},
+		{content: "kubectl delete pod web-0", isDangerous: true},
+		{content: "kubectl -n prod delete deployment web", isDangerous: true},
+		{content: "kubectl get pods && rm -i x", isDangerous: false},
+		{content: "kubectl get pods; echo delete", isDangerous: false},
+		{content: "kubectl get pods | grep delete", isDangerous: false},
+		{content: "kubectl get pods\necho delete", isDangerous: false},
+		{content: "ls && rm -rf build", isDangerous: true},
+		{content: "echo $(kubectl delete pod web-0)", isDangerous: true},
+	}
+	for _, test := range tests {
+		t.Run(test.content, func(t *testing.T) {
+			reason, isDangerous := dangerReason(Command{}, test.content)
+			if isDangerous != test.isDangerous {
+				t.Errorf("dangerReason(%q) = %q, %v, want %v", test.content, reason, isDangerous, test.isDangerous)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+// This file contains opening commands by their id, the id is the path of the markdown file in the wiki and the
+// anchor of the title, like "network/interfaces#create-a-dummy-networking-interface". Commands can be opened with:
+//
+//	cwc show network/interfaces#create-a-dummy-networking-interface
+//	cwc cwc://network/interfaces#create-a-dummy-networking-interface
+//	cwc https://commands.wiki/commands/network/interfaces/#create-a-dummy-networking-interface
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// isCommandLink returns if the argument is a cwc:// or commands.wiki link
+func isCommandLink(arg string) bool {
+	for _, prefix := range []string{"cwc://", "https://commands.wiki/", "http://commands.wiki/", "https://www.commands.wiki/"} {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandIDFromLink returns the id of the command a link points to, ids are returned as they are
+func commandIDFromLink(link string) (string, error) {
+	if !isCommandLink(link) {
+		return link, nil
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	path := u.Path
+	if u.Scheme == "cwc" {
+		path = u.Host + u.Path
+	}
+	// The pages of the wiki are below /commands/ and end with a slash
+	path = strings.TrimPrefix(strings.Trim(path, "/"), "commands/")
+	if u.Fragment == "" {
+		return path, nil
+	}
+	return path + "#" + u.Fragment, nil
+}
+
+// findCommandsByID returns the command with the id, an id without an anchor returns all commands of the page
+func findCommandsByID(commands []Command, id string) []Command {
+	var pageCommands []Command
+	for _, cmd := range commands {
+		if strings.EqualFold(cmd.ID, id) {
+			return []Command{cmd}
+		}
+		if !strings.Contains(id, "#") && strings.HasPrefix(strings.ToLower(cmd.ID), strings.ToLower(id)+"#") {
+			pageCommands = append(pageCommands, cmd)
+		}
+	}
+	return pageCommands
+}
+
+// showCommandByID opens the command with the id or link, anything that does not resolve to a command is searched
+// for instead
+func showCommandByID(idOrLink string) {
+	id, err := commandIDFromLink(idOrLink)
+	if err != nil {
+		log.Warn("invalid link, searching for it instead", "link", idOrLink, "error", err)
+		search(idOrLink)
+		return
+	}
+	matches := findCommandsByID(loadIndex(), id)
+	if len(matches) == 0 {
+		if isCommandLink(idOrLink) {
+			log.Warn("the command was not found in the index, run \"cwc update\" if it was added recently", "id", id)
+			search(searchTermsForID(id))
+			return
+		}
+		search(idOrLink)
+		return
+	}
+	showCommands(matches, "")
+}
+
+// searchTermsForID returns the words of the title anchor of the id, or of the page if it has no anchor, like
+// "create a dummy networking interface" for "network/interfaces#create-a-dummy-networking-interface"
+func searchTermsForID(id string) string {
+	page, anchor, _ := strings.Cut(id, "#")
+	if anchor == "" {
+		anchor = page[strings.LastIndex(page, "/")+1:]
+	}
+	return strings.Join(strings.FieldsFunc(anchor, func(r rune) bool { return r == '-' || r == '_' }), " ")
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestSearchTermsForID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "network/interfaces#create-a-dummy-networking-interface", want: "create a dummy networking interface"},
+		{id: "network/disk-usage", want: "disk usage"},
+		{id: "tools", want: "tools"},
+	}
+	for _, test := range tests {
+		if got := searchTermsForID(test.id); got != test.want {
+			t.Errorf("searchTermsForID(%q) = %q, want %q", test.id, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+// This file contains "cwc doctor" (or "cwc status"), it collects everything that is useful when cwc does not work
+// as expected and prints it, or with --json prints it in a form that can be attached to a bug report. It only
+// reads, the index is never updated or created by it.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staleTempFileAge is how old a temp file of cwc has to be before it is reported, commands may still be running
+const staleTempFileAge = time.Hour
+
+type doctorIndex struct {
+	Path          string    `json:"path"`
+	Store         string    `json:"store"`
+	Size          int64     `json:"size"`
+	SchemaVersion int       `json:"schemaVersion"`
+	MigratedFrom  int       `json:"migratedFrom,omitempty"`
+	Commit        string    `json:"commit,omitempty"`
+	Created       time.Time `json:"created"`
+	Commands      int       `json:"commands"`
+	AiCommands    int       `json:"aiCommands"`
+	Error         string    `json:"error,omitempty"`
+}
+
+type doctorReport struct {
+	Version         string            `json:"version"`
+	ConfigPath      string            `json:"configPath"`
+	Config          map[string]string `json:"config"`
+	Repo            string            `json:"repo"`
+	Source          string            `json:"source"`
+	Branch          string            `json:"branch"`
+	Pin             string            `json:"pin,omitempty"`
+	ContentRoot     string            `json:"contentRoot"`
+	LastUpdate      *time.Time        `json:"lastUpdate"`
+	UpdateRunning   bool              `json:"updateRunning"`
+	LastUpdateError string            `json:"lastUpdateError,omitempty"`
+	Index           doctorIndex       `json:"index"`
+	Git             string            `json:"git"`
+	AiProvider      string            `json:"aiProvider"`
+	AiModel         string            `json:"aiModel"`
+	AiApiKeyEnv     string            `json:"aiApiKeyEnv,omitempty"`
+	AiApiKeySet     bool              `json:"aiApiKeySet"`
+	VerifySigned    bool              `json:"verifySignatures"`
+	ParseWarnings   []string          `json:"parseWarnings"`
+	StaleTempFiles  []string          `json:"staleTempFiles"`
+	Problems        []string          `json:"problems"`
+}
+
+// runDoctor prints the report and exits with 1 if problems were found
+func runDoctor(asJson bool) {
+	report := collectDoctorReport()
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printDoctorReport(report)
+	}
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func collectDoctorReport() doctorReport {
+	report := doctorReport{
+		Version:        sha + " (" + branch + ")",
+		Config:         map[string]string{},
+		ParseWarnings:  []string{},
+		StaleTempFiles: []string{},
+		Problems:       []string{},
+	}
+
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		report.Problems = append(report.Problems, "the config directory could not be found: "+err.Error())
+		return report
+	}
+	report.ConfigPath = filepath.Join(configPath, "commands-wiki", "config")
+	config, err := GetConfig()
+	if err != nil {
+		report.Problems = append(report.Problems, "the config could not be read: "+err.Error())
+	}
+	for key, value := range config {
+		report.Config[key] = value
+	}
+
+	report.Repo, _ = GetRepo()
+	report.Source = sourceKindOf(report.Repo).String()
+	report.Branch = getDefaultBranch()
+	report.Pin = getPin()
+	report.ContentRoot = getContentRoot()
+	if lastUpdate, err := getLastIndexUpdate(); err == nil {
+		lastUpdateTime := time.UnixMilli(int64(lastUpdate))
+		report.LastUpdate = &lastUpdateTime
+	}
+	report.UpdateRunning = isUpdateRunning()
+	report.LastUpdateError = lastUpdateLogError(filepath.Join(configPath, "commands-wiki", "update.log"))
+	if report.LastUpdateError != "" {
+		report.Problems = append(report.Problems, "the last background update failed, see "+filepath.Join(configPath, "commands-wiki", "update.log"))
+	}
+
+	commands := collectDoctorIndex(&report)
+	report.ParseWarnings = append(report.ParseWarnings, commandParseWarnings(commands)...)
+	if len(report.ParseWarnings) > 0 {
+		report.Problems = append(report.Problems, "the markdown of some commands has problems, see the parse warnings")
+	}
+
+	if output, err := exec.Command("git", "--version").Output(); err == nil {
+		report.Git = strings.TrimSpace(string(output))
+	}
+
+	report.AiProvider, report.AiModel, err = configuredProviderAndModel()
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+	}
+	report.AiApiKeyEnv = GetValueNoError("ai-api-key-env", defaultApiKeyEnvs[report.AiProvider])
+	if report.AiApiKeyEnv != "" {
+		report.AiApiKeySet = os.Getenv(report.AiApiKeyEnv) != ""
+	}
+
+	report.VerifySigned = isSignatureVerificationEnabled()
+	if report.VerifySigned && sourceKindOf(report.Repo) != gitSource {
+		report.Problems = append(report.Problems, "signatures are verified but the source is a "+report.Source+" which can not be signed, updates will be refused")
+	}
+	for _, key := range []string{"trusted-gpg-keys", "trusted-ssh-signers"} {
+		if path := GetValueNoError(key, ""); path != "" {
+			if _, err := os.Stat(path); err != nil {
+				report.Problems = append(report.Problems, key+" can not be read, updates will be refused: "+err.Error())
+			}
+		}
+	}
+
+	report.StaleTempFiles = staleTempFiles()
+	if len(report.StaleTempFiles) > 0 {
+		report.Problems = append(report.Problems, "temp files of cwc were left behind in "+os.TempDir()+", they can be removed when no command is running")
+	}
+	return report
+}
+
+// collectDoctorIndex adds the state of the index to the report and returns its commands
+func collectDoctorIndex(report *doctorReport) []Command {
+	report.Index.Store = GetValueNoError("index-store", "json")
+	indexFilePath, err := getIndexFilePath()
+	if err != nil {
+		report.Index.Error = err.Error()
+		report.Problems = append(report.Problems, "the index could not be found: "+err.Error())
+		return nil
+	}
+	report.Index.Path = indexFilePath
+	info, err := os.Stat(indexFilePath)
+	if err != nil {
+		report.Index.Error = err.Error()
+		report.Problems = append(report.Problems, "there is no index, run \"cwc update\" to create it")
+		return nil
+	}
+	report.Index.Size = info.Size()
+
+	envelope, err := readIndexFile(indexFilePath)
+	if err != nil {
+		report.Index.Error = err.Error()
+		report.Problems = append(report.Problems, "the index can not be read, run \"cwc update\" to rebuild it: "+err.Error())
+		return nil
+	}
+	report.Index.SchemaVersion = envelope.SchemaVersion
+	report.Index.MigratedFrom = envelope.migratedFrom
+	report.Index.Commit = envelope.Meta.Commit
+	report.Index.Created = envelope.Meta.Created
+	report.Index.Commands = len(envelope.Commands)
+	for _, cmd := range envelope.Commands {
+		if cmd.AiGenerated {
+			report.Index.AiCommands++
+		}
+	}
+	return envelope.Commands
+}
+
+// commandParseWarnings returns the problems of the commands that make them unusable or break their variables
+func commandParseWarnings(commands []Command) []string {
+	var warnings []string
+	for _, cmd := range commands {
+		if strings.TrimSpace(cmd.Content) == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: %q has no code block", cmd.ID, cmd.CmdTitle))
+			continue
+		}
+		for variable, variableMetadata := range cmd.Metadata {
+			if problem := validateVariableValidation(variable, variableMetadata["validation"]); problem != "" {
+				warnings = append(warnings, cmd.ID+": "+problem)
+			}
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// lastUpdateLogError returns the error the last background update failed with
+func lastUpdateLogError(logPath string) string {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	var lastError string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), " ERRO ") || strings.Contains(scanner.Text(), " FATA ") {
+			lastError = scanner.Text()
+		}
+	}
+	return lastError
+}
+
+// staleTempFiles returns the scripts and files cwc writes to the temp directory that are still there after
+// staleTempFileAge, they are normally removed when the command finished
+func staleTempFiles() []string {
+	stale := []string{}
+	for _, pattern := range []string{"cwc-exec-*", "cwc-ai-*.md", "cwc-signature-*"} {
+		matches, _ := filepath.Glob(filepath.Join(os.TempDir(), pattern))
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && time.Since(info.ModTime()) > staleTempFileAge {
+				stale = append(stale, match)
+			}
+		}
+	}
+	return stale
+}
+
+func printDoctorReport(report doctorReport) {
+	fmt.Println("cwc " + report.Version)
+	fmt.Println()
+	printDoctorLine("Config", report.ConfigPath)
+	keys := make([]string, 0, len(report.Config))
+	for key := range report.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		printDoctorLine("  "+key, report.Config[key])
+	}
+	printDoctorLine("Source", report.Source+" "+report.Repo)
+	ref := wikiRef{Branch: report.Branch, Pin: report.Pin}
+	printDoctorLine("Ref", ref.String())
+	printDoctorLine("Content root", report.ContentRoot)
+
+	lastUpdate := "never"
+	if report.LastUpdate != nil {
+		lastUpdate = report.LastUpdate.Format("2006-01-02 15:04") + " (" + time.Since(*report.LastUpdate).Round(time.Minute).String() + " ago)"
+	}
+	if report.UpdateRunning {
+		lastUpdate += ", an update is running"
+	}
+	printDoctorLine("Last update", lastUpdate)
+	if report.LastUpdateError != "" {
+		printDoctorLine("Update error", report.LastUpdateError)
+	}
+
+	if report.Index.Error != "" {
+		printDoctorLine("Index", report.Index.Path+" ("+report.Index.Error+")")
+	} else {
+		schema := strconv.Itoa(report.Index.SchemaVersion)
+		if report.Index.MigratedFrom != 0 {
+			schema = strconv.Itoa(report.Index.MigratedFrom) + ", rebuilt on the next update"
+		}
+		printDoctorLine("Index", fmt.Sprintf("%s (%s, schema %s, %.1f KB)", report.Index.Path, report.Index.Store, schema, float64(report.Index.Size)/1024))
+		printDoctorLine("Commands", fmt.Sprintf("%d (%d generated)", report.Index.Commands, report.Index.AiCommands))
+		if report.Index.Commit != "" {
+			printDoctorLine("Wiki commit", report.Index.Commit)
+		}
+	}
+
+	git := report.Git
+	if git == "" {
+		git = "not installed, cwc does not need it"
+	}
+	printDoctorLine("git", git)
+	ai := report.AiProvider + " " + report.AiModel
+	if report.AiApiKeyEnv != "" {
+		ai += " (" + report.AiApiKeyEnv + " is " + map[bool]string{true: "set", false: "not set"}[report.AiApiKeySet] + ")"
+	}
+	printDoctorLine("AI", ai)
+	printDoctorLine("Signatures", map[bool]string{true: "required for updates", false: "not verified"}[report.VerifySigned])
+
+	printDoctorList("Parse warnings", report.ParseWarnings)
+	printDoctorList("Stale temp files", report.StaleTempFiles)
+	if len(report.Problems) == 0 {
+		fmt.Println()
+		fmt.Println("No problems found")
+		return
+	}
+	printDoctorList("Problems", report.Problems)
+}
+
+func printDoctorLine(name string, value string) {
+	fmt.Printf("%-20s %s\n", name, value)
+}
+
+func printDoctorList(name string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println(name + " (" + strconv.Itoa(len(values)) + ")")
+	for _, value := range values {
+		fmt.Println("  - " + value)
+	}
+}
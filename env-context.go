@@ -0,0 +1,145 @@
+package main
+
+// This file contains the environment summary that can be sent with "cwc ai" prompts, so the model writes
+// commands for the package manager and tools of this machine instead of generic ones.
+// Only the names of the OS, shell, tools and project type are collected, no paths, user or host names.
+//
+//	ai-environment-context  never | ask | always (never by default)
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+var environmentPackageManagers = []string{
+	"apt", "dnf", "yum", "pacman", "zypper", "apk", "emerge", "nix", "snap", "flatpak",
+	"brew", "port", "winget", "choco", "scoop",
+}
+
+var environmentTools = []string{
+	"git", "docker", "podman", "kubectl", "helm", "systemctl", "curl", "wget", "jq", "ssh",
+	"python3", "node", "npm", "go", "cargo", "java", "terraform", "ansible", "aws", "gcloud", "az",
+}
+
+// environmentProjectMarkers maps files in the current directory to the type of project they belong to
+var environmentProjectMarkers = []struct {
+	file    string
+	project string
+}{
+	{"go.mod", "Go"},
+	{"package.json", "Node.js"},
+	{"Cargo.toml", "Rust"},
+	{"pyproject.toml", "Python"},
+	{"requirements.txt", "Python"},
+	{"pom.xml", "Java (Maven)"},
+	{"build.gradle", "Java (Gradle)"},
+	{"Gemfile", "Ruby"},
+	{"composer.json", "PHP"},
+	{"Dockerfile", "Docker"},
+	{"docker-compose.yml", "Docker Compose"},
+	{"compose.yaml", "Docker Compose"},
+	{"Makefile", "Make"},
+}
+
+// collectEnvironment returns a summary of the machine, one fact per line
+func collectEnvironment() []string {
+	summary := []string{"OS: " + operatingSystemName() + " (" + runtime.GOARCH + ")"}
+
+	if shell := os.Getenv("SHELL"); shell != "" {
+		summary = append(summary, "Shell: "+filepath.Base(shell))
+	}
+	if packageManagers := toolsOnPath(environmentPackageManagers); len(packageManagers) > 0 {
+		summary = append(summary, "Package managers: "+strings.Join(packageManagers, ", "))
+	}
+	if tools := toolsOnPath(environmentTools); len(tools) > 0 {
+		summary = append(summary, "Tools: "+strings.Join(tools, ", "))
+	}
+
+	var projects []string
+	for _, marker := range environmentProjectMarkers {
+		if _, err := os.Stat(marker.file); err == nil && !slices.Contains(projects, marker.project) {
+			projects = append(projects, marker.project)
+		}
+	}
+	if len(projects) > 0 {
+		summary = append(summary, "Current directory: "+strings.Join(projects, ", ")+" project")
+	}
+	return summary
+}
+
+// operatingSystemName returns the name of the distribution on linux and the name of the OS elsewhere
+func operatingSystemName() string {
+	if runtime.GOOS != "linux" {
+		if runtime.GOOS == "darwin" {
+			return "macOS"
+		}
+		return runtime.GOOS
+	}
+	file, err := os.Open("/etc/os-release")
+	if err != nil {
+		return "linux"
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); ok {
+			return strings.Trim(name, `"'`)
+		}
+	}
+	return "linux"
+}
+
+func toolsOnPath(tools []string) []string {
+	var found []string
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err == nil {
+			found = append(found, tool)
+		}
+	}
+	return found
+}
+
+// environmentContextPrompt returns the environment summary to add to the system prompt, depending on the
+// config it is shown to the user and only sent when they agree
+func environmentContextPrompt() string {
+	mode := GetValueNoError("ai-environment-context", "never")
+	if mode != "ask" && mode != "always" {
+		if mode != "never" {
+			log.Warn("Unknown ai-environment-context, expected never, ask or always", "value", mode)
+		}
+		return ""
+	}
+	// The command will run on another machine, the environment of this one would only mislead the model
+	if targetHost != "" && targetHost != "local" {
+		fmt.Println("The environment of this machine is not sent to the model, the command will run on " + targetHost)
+		return ""
+	}
+
+	summary := collectEnvironment()
+	fmt.Println("This information about your environment will be sent to the model:")
+	for _, line := range summary {
+		fmt.Println("  " + line)
+	}
+	if mode == "ask" {
+		fmt.Print("Send it? [y/N] ")
+		var input string
+		fmt.Scanln(&input)
+		if !strings.EqualFold(input, "y") && !strings.EqualFold(input, "yes") {
+			return ""
+		}
+	}
+
+	prompt := "\nThe command will be run on a machine with this environment, use its package manager and the tools available on it:\n"
+	for _, line := range summary {
+		prompt += "- " + line + "\n"
+	}
+	return prompt
+}
@@ -0,0 +1,49 @@
+package main
+
+// This file contains "cwc explain <command>", it asks the model to explain an arbitrary command in the same
+// markdown layout as the wiki so the explained command can be saved as a reusable command
+
+import (
+	"github.com/charmbracelet/log"
+)
+
+const explainInstructions = `You will get a command, explain it in markdown formatted like the example below.
+Write a title that describes what the command does, a short description, the command in a code block and a numbered list that explains every argument of the command.
+`
+
+const explainPlaceholderInstructions = `Replace values that are specific to this invocation (file names, paths, hosts, names, ...) with variables like ` + "`<variable_name>`" + ` and add a metadata line for each of them that uses the original value as the placeholder.
+`
+
+const explainVerbatimInstructions = `Keep the command exactly as it was given, do not add any variables.
+`
+
+func runCommandExplanation(command string, inferPlaceholders bool) {
+	provider, err := newCompletionProvider()
+	if err != nil {
+		log.Fatal("Failed to set up the ai provider", "error", err)
+	}
+
+	instructions := explainInstructions
+	if inferPlaceholders {
+		instructions += explainPlaceholderInstructions
+	} else {
+		instructions += explainVerbatimInstructions
+	}
+	conversation := []completionMessage{
+		{
+			Role:    "system",
+			Content: instructions + markdownExample + cwcInstructions,
+		},
+		{
+			Role:    "user",
+			Content: command,
+		},
+	}
+
+	b := newAiCommandGeneration(command, provider, conversation)
+	generated, accepted := runAiCommandGenerationModel(b)
+	if !accepted {
+		return
+	}
+	saveAiCommand("explain "+command, generated)
+}
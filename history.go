@@ -0,0 +1,49 @@
+package main
+
+// This file contains everything for the command history, every executed command is appended as a json line
+// to the "history" file in the config directory
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type historyEntry struct {
+	Time     time.Time
+	Title    string
+	Host     string
+	Command  string
+	ExitCode int
+}
+
+func appendHistory(entry historyEntry) error {
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		return err
+	}
+	historyPath := filepath.Join(configPath, "commands-wiki", "history")
+	err = os.MkdirAll(filepath.Dir(historyPath), 0744)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(historyPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(entry)
+}
+
+// maskSecrets replaces the values of the secrets in env ("KEY=value") in the content with asterisks
+func maskSecrets(content string, env []string) string {
+	for _, variable := range env {
+		_, value, _ := strings.Cut(variable, "=")
+		if value != "" {
+			content = strings.ReplaceAll(content, value, "********")
+		}
+	}
+	return content
+}
@@ -0,0 +1,105 @@
+package main
+
+// This file contains the on-disk format of the index. The index is a json envelope with the schema version, the
+// metadata of the build and the commands. Indexes written by older versions of cwc are migrated when they are
+// read, version 1 is the bare json array of commands written before the envelope existed which stored the markdown
+// of the commands in separate files.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const indexSchemaVersion = 2
+
+type indexMeta struct {
+	CwcVersion string    `json:"cwcVersion"`
+	Repo       string    `json:"repo"`
+	Commit     string    `json:"commit"`
+	Created    time.Time `json:"created"`
+}
+
+type indexEnvelope struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Meta          indexMeta `json:"meta"`
+	Commands      []Command `json:"commands"`
+
+	// migratedFrom is the schema version the index was read as, it is 0 if the index did not need a migration
+	migratedFrom int
+}
+
+// legacyCommand is a command of version 1, its markdown was stored in MarkdownFile
+type legacyCommand struct {
+	Command
+	MarkdownFile string
+}
+
+// indexMigrations upgrade the raw index of the version to the raw index of the next version
+var indexMigrations = map[int]func(raw []byte) ([]byte, error){
+	1: func(raw []byte) ([]byte, error) {
+		var commands []legacyCommand
+		err := json.Unmarshal(raw, &commands)
+		if err != nil {
+			return nil, err
+		}
+		envelope := indexEnvelope{SchemaVersion: 2}
+		for _, cmd := range commands {
+			// The markdown files are removed on the next update, which also parses all files again and assigns
+			// the full ids
+			if markdown, err := os.ReadFile(cmd.MarkdownFile); err == nil {
+				cmd.Command.Markdown = string(markdown)
+			}
+			if cmd.SourceFile != "" {
+				cmd.Command.ID = commandID(filepath.Base(cmd.SourceFile), cmd.CmdTitle)
+			}
+			envelope.Commands = append(envelope.Commands, cmd.Command)
+		}
+		return json.Marshal(envelope)
+	},
+}
+
+// decodeIndex reads an index of any known schema version and migrates it to the current one
+func decodeIndex(raw []byte) (indexEnvelope, error) {
+	raw = bytes.TrimSpace(raw)
+	version := 1
+	if !bytes.HasPrefix(raw, []byte("[")) {
+		var header struct {
+			SchemaVersion int `json:"schemaVersion"`
+		}
+		err := json.Unmarshal(raw, &header)
+		if err != nil {
+			return indexEnvelope{}, err
+		}
+		version = header.SchemaVersion
+	}
+	if version > indexSchemaVersion {
+		return indexEnvelope{}, fmt.Errorf("the index has schema version %d which is newer than this cwc supports (%d), run \"cwc update\" to rebuild it", version, indexSchemaVersion)
+	}
+
+	// Migrations only go to the next version, keep going until the index is current
+	for current := version; current < indexSchemaVersion; current++ {
+		migrate, ok := indexMigrations[current]
+		if !ok {
+			return indexEnvelope{}, fmt.Errorf("the index has schema version %d which can not be migrated, run \"cwc update\" to rebuild it", current)
+		}
+		var err error
+		raw, err = migrate(raw)
+		if err != nil {
+			return indexEnvelope{}, err
+		}
+	}
+
+	var envelope indexEnvelope
+	err := json.Unmarshal(raw, &envelope)
+	if err != nil {
+		return indexEnvelope{}, err
+	}
+	if version != indexSchemaVersion {
+		envelope.migratedFrom = version
+	}
+	return envelope, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeIndex(t *testing.T) {
+	markdownFile := filepath.Join(t.TempDir(), "foo-bar.md")
+	err := os.WriteFile(markdownFile, []byte("### Foo bar\nSays foo\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Paths are embedded in json, windows paths need their backslashes escaped
+	markdownFileJson := strings.ReplaceAll(markdownFile, `\`, `\\`)
+
+	tests := []struct {
+		name             string
+		raw              string
+		wantMigratedFrom int
+		wantCommit       string
+		wantID           string
+		wantMarkdown     string
+	}{
+		{
+			name:             "version 1 bare array",
+			raw:              `[{"CmdTitle":"Foo bar","Content":"echo foo","MarkdownFile":"` + markdownFileJson + `","SourceFile":"/wiki/net/tools.md"}]`,
+			wantMigratedFrom: 1,
+			wantID:           "tools#foo-bar",
+			wantMarkdown:     "### Foo bar\nSays foo\n",
+		},
+		{
+			name:         "current version",
+			raw:          `{"schemaVersion":2,"meta":{"commit":"abc"},"commands":[{"ID":"tools#foo-bar","CmdTitle":"Foo bar","Content":"echo foo","Markdown":"inline"}]}`,
+			wantCommit:   "abc",
+			wantID:       "tools#foo-bar",
+			wantMarkdown: "inline",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			envelope, err := decodeIndex([]byte(test.raw))
+			if err != nil {
+				t.Fatalf("decodeIndex() error = %v", err)
+			}
+			if envelope.SchemaVersion != indexSchemaVersion {
+				t.Errorf("SchemaVersion = %d, want %d", envelope.SchemaVersion, indexSchemaVersion)
+			}
+			if envelope.migratedFrom != test.wantMigratedFrom {
+				t.Errorf("migratedFrom = %d, want %d", envelope.migratedFrom, test.wantMigratedFrom)
+			}
+			if envelope.Meta.Commit != test.wantCommit {
+				t.Errorf("Meta.Commit = %q, want %q", envelope.Meta.Commit, test.wantCommit)
+			}
+			if len(envelope.Commands) != 1 {
+				t.Fatalf("got %d commands, want 1", len(envelope.Commands))
+			}
+			cmd := envelope.Commands[0]
+			if cmd.CmdTitle != "Foo bar" || cmd.Content != "echo foo" {
+				t.Errorf("command = %+v, want the title and content to be kept", cmd)
+			}
+			if cmd.ID != test.wantID {
+				t.Errorf("ID = %q, want %q", cmd.ID, test.wantID)
+			}
+			if cmd.Markdown != test.wantMarkdown {
+				t.Errorf("Markdown = %q, want %q", cmd.Markdown, test.wantMarkdown)
+			}
+		})
+	}
+}
+
+func TestDecodeIndexErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr string
+	}{
+		{name: "newer than supported", raw: `{"schemaVersion":99,"commands":[]}`, wantErr: "newer than this cwc supports"},
+		{name: "unknown version", raw: `{"schemaVersion":0,"commands":[]}`, wantErr: "can not be migrated"},
+		{name: "damaged", raw: `{"schemaVersion":`, wantErr: "unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeIndex([]byte(test.raw))
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("decodeIndex() error = %v, want it to contain %q", err, test.wantErr)
+			}
+		})
+	}
+}
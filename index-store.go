@@ -0,0 +1,68 @@
+package main
+
+// This file contains the storage backends of the index, the backend is selected in the config:
+//
+//	index-store  json | gob (json by default)
+//
+// json is readable and can be migrated from older versions, gob is a compact binary snapshot that decodes faster
+// for large wikis. Switching the backend rebuilds the index on the next search.
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// IndexStore encodes and decodes the index, writing the files atomically is done by writeIndexFile
+type IndexStore interface {
+	// FileName is the name of the index file in the index directory
+	FileName() string
+	Encode(envelope indexEnvelope) ([]byte, error)
+	Decode(raw []byte) (indexEnvelope, error)
+}
+
+type jsonIndexStore struct{}
+
+func (jsonIndexStore) FileName() string { return "index" }
+
+func (jsonIndexStore) Encode(envelope indexEnvelope) ([]byte, error) {
+	raw, err := json.Marshal(envelope)
+	return append(raw, '\n'), err
+}
+
+func (jsonIndexStore) Decode(raw []byte) (indexEnvelope, error) {
+	return decodeIndex(raw)
+}
+
+type gobIndexStore struct{}
+
+func (gobIndexStore) FileName() string { return "index.gob" }
+
+func (gobIndexStore) Encode(envelope indexEnvelope) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(envelope)
+	return buffer.Bytes(), err
+}
+
+func (gobIndexStore) Decode(raw []byte) (indexEnvelope, error) {
+	var envelope indexEnvelope
+	err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&envelope)
+	if err != nil {
+		return indexEnvelope{}, err
+	}
+	// gob snapshots are not migrated, they are rebuilt from the source instead
+	if envelope.SchemaVersion != indexSchemaVersion {
+		return indexEnvelope{}, fmt.Errorf("the index has schema version %d and this cwc uses %d, run \"cwc update\" to rebuild it", envelope.SchemaVersion, indexSchemaVersion)
+	}
+	return envelope, nil
+}
+
+// getIndexStore returns the backend selected in the config
+func getIndexStore() IndexStore {
+	switch GetValueNoError("index-store", "json") {
+	case "gob":
+		return gobIndexStore{}
+	}
+	return jsonIndexStore{}
+}
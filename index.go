@@ -4,18 +4,22 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
-	"strconv"
 	"time"
+
+	"github.com/charmbracelet/log"
 )
 
 type Command struct {
+	ID             string
 	CmdTitle       string
 	CmdDescription string
 	Content        string
+	Language       string
 	Variables      []string
-	MarkdownFile   string
+	Markdown       string
 	Metadata       map[string]map[string]string
 	AiGenerated    bool
+	SourceFile     string
 }
 
 func (i Command) Title() string       { return i.CmdTitle }
@@ -23,31 +27,42 @@ func (i Command) Description() string { return i.CmdDescription }
 func (i Command) FilterValue() string { return i.CmdTitle }
 
 func readIndex() ([]Command, error) {
-	configPath, err := os.UserConfigDir()
-	if err != nil {
-		return nil, err
-	}
+	envelope, err := readIndexEnvelope()
+	return envelope.Commands, err
+}
 
-	repo_name, err := GetRepoName()
+// readIndexEnvelope reads the index with its metadata
+func readIndexEnvelope() (indexEnvelope, error) {
+	indexPath, err := getIndexFilePath()
 	if err != nil {
-		return nil, err
+		return indexEnvelope{}, err
 	}
-	indexPath := filepath.Join(configPath, "commands-wiki", "index", repo_name, "index")
-	file, err := os.Open(indexPath)
+	envelope, err := readIndexFile(indexPath)
 	if err != nil {
-		return nil, err
+		// The index is replaced by renaming, the backup is the previous version if the index is damaged or
+		// missing for a moment
+		backup, backupErr := readIndexFile(indexPath + ".bak")
+		if backupErr != nil {
+			return indexEnvelope{}, err
+		}
+		if !os.IsNotExist(err) {
+			log.Warn("The index is damaged, using the previous index, run \"cwc update\" to rebuild it", "error", err)
+		}
+		return backup, nil
 	}
-	defer file.Close()
-	var commands []Command
-	err = json.NewDecoder(file).Decode(&commands)
+	return envelope, nil
+}
+
+func readIndexFile(path string) (indexEnvelope, error) {
+	raw, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return indexEnvelope{}, err
 	}
-	return commands, nil
+	return getIndexStore().Decode(raw)
 }
 
 func getLastIndexUpdate() (uint64, error) {
-	configPath, err := os.UserConfigDir()
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		return 0, err
 	}
@@ -71,7 +86,7 @@ func getLastIndexUpdate() (uint64, error) {
 }
 
 func setIndexUpdateTimeToNow() {
-	configPath, err := os.UserConfigDir()
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		return
 	}
@@ -89,58 +104,71 @@ func setIndexUpdateTimeToNow() {
 	json.NewEncoder(file).Encode(uint64(time.Now().UnixMilli()))
 }
 
-func checkIfUpdateNeeded() {
-	lastUpdate, err := getLastIndexUpdate()
-	if err != nil {
-		return
+// getDefaultBranch returns the branch passed to "cwc update --branch", or the one in the config. It is empty when
+// the default branch of the repository is followed.
+func getDefaultBranch() string {
+	var branch string
+	err := readIndexMetadata("branch", &branch)
+	if err != nil || branch == "" {
+		return GetValueNoError("branch", "")
 	}
+	return branch
+}
 
-	// Get the update interval from the config
-	timeout, err := strconv.ParseUint(GetValueNoError("git-update-interval", "86400000"), 10, 64)
-	if err != nil {
-		timeout = 86400000
+// getPin returns the tag or commit passed to "cwc update --pin", or the one in the config
+func getPin() string {
+	var pin string
+	err := readIndexMetadata("pin", &pin)
+	if err != nil || pin == "" {
+		return GetValueNoError("pin", "")
+	}
+	return pin
+}
+
+// getIndexRef returns what the wiki is synced to when the index is updated
+func getIndexRef() wikiRef {
+	return wikiRef{Branch: getDefaultBranch(), Pin: getPin()}
+}
+
+// setIndexRef remembers the branch and pin that were passed as flags to "cwc update" so the background updates
+// stay on them, the config is used for the ones that were not passed or are empty
+func setIndexRef(ref wikiRef, flags map[string]bool) {
+	if flags["branch"] {
+		writeIndexMetadata("branch", ref.Branch)
 	}
-	if uint64(time.Now().UnixMilli())-lastUpdate > timeout {
-		// Update the index
-		default_repo, err := GetRepo()
-		if err != nil {
-			return
-		}
-		err = updateIndex(default_repo, getDefaultBranch())
-		if err != nil {
-			return
-		}
+	if flags["pin"] {
+		writeIndexMetadata("pin", ref.Pin)
 	}
 }
 
-func getDefaultBranch() string {
-	defaultBranch := GetValueNoError("branch", "master")
-	configPath, err := os.UserConfigDir()
+// getContentRoot returns the content root the index was built from, or the one in the config
+func getContentRoot() string {
+	defaultContentRoot := GetValueNoError("content-root", wikiContentRoot)
+	configPath, err := getUserConfigDir()
 	if err != nil {
-		return defaultBranch
+		return defaultContentRoot
 	}
 
 	repo_name, err := GetRepoName()
 	if err != nil {
-		return defaultBranch
+		return defaultContentRoot
 	}
-	branchPath := filepath.Join(configPath, "commands-wiki", "index", repo_name, "branch")
-	file, err := os.Open(branchPath)
+	contentRootPath := filepath.Join(configPath, "commands-wiki", "index", repo_name, "contentRoot")
+	file, err := os.Open(contentRootPath)
 	if err != nil {
-		return defaultBranch
+		return defaultContentRoot
 	}
 	defer file.Close()
-	var branch string
-	err = json.NewDecoder(file).Decode(&branch)
+	var contentRoot string
+	err = json.NewDecoder(file).Decode(&contentRoot)
 	if err != nil {
-		return defaultBranch
+		return defaultContentRoot
 	}
-	return branch
-
+	return contentRoot
 }
 
-func setIndexBranch(branch string) {
-	configPath, err := os.UserConfigDir()
+func setIndexContentRoot(contentRoot string) {
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		return
 	}
@@ -149,17 +177,17 @@ func setIndexBranch(branch string) {
 	if err != nil {
 		return
 	}
-	branchPath := filepath.Join(configPath, "commands-wiki", "index", repo_name, "branch")
-	file, err := os.Create(branchPath)
+	contentRootPath := filepath.Join(configPath, "commands-wiki", "index", repo_name, "contentRoot")
+	file, err := os.Create(contentRootPath)
 	if err != nil {
 		return
 	}
 	defer file.Close()
-	json.NewEncoder(file).Encode(branch)
+	json.NewEncoder(file).Encode(contentRoot)
 }
 
 func getIndexBranch() (string, error) {
-	configPath, err := os.UserConfigDir()
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		return "", err
 	}
@@ -182,9 +210,28 @@ func getIndexBranch() (string, error) {
 	return branch, nil
 }
 
-func writeIndex(commands []Command) error {
+// readIndexMetadata decodes the json file name in the index directory into v
+func readIndexMetadata(name string, v any) error {
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		return err
+	}
+
+	repo_name, err := GetRepoName()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filepath.Join(configPath, "commands-wiki", "index", repo_name, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewDecoder(file).Decode(v)
+}
 
-	configPath, err := os.UserConfigDir()
+// writeIndexMetadata writes v as json to the file name in the index directory
+func writeIndexMetadata(name string, v any) error {
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		return err
 	}
@@ -193,34 +240,108 @@ func writeIndex(commands []Command) error {
 	if err != nil {
 		return err
 	}
+	file, err := os.Create(filepath.Join(configPath, "commands-wiki", "index", repo_name, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(v)
+}
+
+// getIndexRevision returns the revision of the source the index was built from, it is empty for sources
+// without revisions
+func getIndexRevision() string {
+	envelope, err := readIndexEnvelope()
+	if err != nil {
+		return ""
+	}
+	return envelope.Meta.Commit
+}
+
+func getIndexFilePath() (string, error) {
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	repo_name, err := GetRepoName()
+	if err != nil {
+		return "", err
+	}
 
 	indexPath := filepath.Join(configPath, "commands-wiki", "index", repo_name)
 	// Create the indexPath if it does not exist
 	err = os.MkdirAll(indexPath, 0744)
 	if err != nil {
-		return err
+		return "", err
 	}
-	indexFilePath := filepath.Join(indexPath, "index")
+	return filepath.Join(indexPath, getIndexStore().FileName()), nil
+}
 
-	// Open the index file
-	indexFile, err := os.OpenFile(indexFilePath, os.O_WRONLY, 0744)
+// lockIndex makes sure only one cwc writes the index at a time
+func lockIndex(indexFilePath string) (func(), error) {
+	return acquireLockFile(indexFilePath+".lock", 10*time.Second)
+}
+
+// modifyIndex reads the index, changes it with modify and writes it back without another cwc writing the
+// index in between
+func modifyIndex(modify func(commands []Command) []Command) error {
+	indexFilePath, err := getIndexFilePath()
+	if err != nil {
+		return err
+	}
+	releaseLock, err := lockIndex(indexFilePath)
 	if err != nil {
 		return err
 	}
+	defer releaseLock()
+	envelope, err := readIndexEnvelope()
+	if err != nil {
+		return err
+	}
+	return writeIndexFile(indexFilePath, modify(envelope.Commands), envelope.Meta.Commit)
+}
 
-	// Write the commands array to the json file
-	jsonBytes, err := json.Marshal(commands)
+// writeIndexFile writes the commands to a temporary file and renames it to the index, so the index is never
+// half written. The previous index is kept as index.bak.
+func writeIndexFile(indexFilePath string, commands []Command, commit string) error {
+	repo, _ := GetRepo()
+	encoded, err := getIndexStore().Encode(indexEnvelope{
+		SchemaVersion: indexSchemaVersion,
+		Meta: indexMeta{
+			CwcVersion: sha,
+			Repo:       repo,
+			Commit:     commit,
+			Created:    time.Now().UTC(),
+		},
+		Commands: commands,
+	})
 	if err != nil {
 		return err
 	}
-	_, err = indexFile.Write(jsonBytes)
+	tmpFile, err := os.CreateTemp(filepath.Dir(indexFilePath), filepath.Base(indexFilePath)+"-*.tmp")
 	if err != nil {
 		return err
 	}
-	indexFile.WriteString("\n")
-	err = indexFile.Close()
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(encoded)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
 	if err != nil {
 		return err
 	}
-	return nil
+	if closeErr != nil {
+		return closeErr
+	}
+
+	// Only replace the backup with an index that can be read
+	if _, err := readIndexFile(indexFilePath); err == nil {
+		err = os.Rename(indexFilePath, indexFilePath+".bak")
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpFile.Name(), indexFilePath)
 }
@@ -0,0 +1,74 @@
+package main
+
+// This file contains everything for choosing how a command is executed based on the language of its code block
+// The interpreter for a language can be changed in the config with lines like "interpreter-python python3 -u",
+// setting it to "none" stops cwc from executing commands in that language.
+
+import (
+	"strings"
+)
+
+type interpreter struct {
+	Args      []string
+	Extension string
+	// SecretReference is the format used to reference a secret environment variable in the script,
+	// commands with secrets are refused if it is empty as the secret would have to be written into the script
+	SecretReference string
+	// SecretQuoting is where in the script the secret reference is read from the environment
+	SecretQuoting secretQuoting
+}
+
+// secretQuoting describes if a secret reference still works inside of the quotes of a script
+type secretQuoting int
+
+const (
+	// quotingExpression references only work outside of string literals, like in python
+	quotingExpression secretQuoting = iota
+	// quotingDoubleQuotes references are also expanded inside of double quotes, like in powershell
+	quotingDoubleQuotes
+	// quotingShell references are expanded inside of double quotes and single quotes can be closed around them,
+	// like in bash
+	quotingShell
+)
+
+// defaultInterpreters maps code block languages to the program that runs them
+var defaultInterpreters = map[string]interpreter{
+	"":           {Args: []string{"bash"}, Extension: ".sh", SecretReference: "${%s}", SecretQuoting: quotingShell},
+	"bash":       {Args: []string{"bash"}, Extension: ".sh", SecretReference: "${%s}", SecretQuoting: quotingShell},
+	"shell":      {Args: []string{"bash"}, Extension: ".sh", SecretReference: "${%s}", SecretQuoting: quotingShell},
+	"sh":         {Args: []string{"sh"}, Extension: ".sh", SecretReference: "${%s}", SecretQuoting: quotingShell},
+	"zsh":        {Args: []string{"zsh"}, Extension: ".zsh", SecretReference: "${%s}", SecretQuoting: quotingShell},
+	"fish":       {Args: []string{"fish"}, Extension: ".fish", SecretReference: "$%s", SecretQuoting: quotingShell},
+	"powershell": {Args: []string{"pwsh", "-NoProfile", "-File"}, Extension: ".ps1", SecretReference: "$env:%s", SecretQuoting: quotingDoubleQuotes},
+	"pwsh":       {Args: []string{"pwsh", "-NoProfile", "-File"}, Extension: ".ps1", SecretReference: "$env:%s", SecretQuoting: quotingDoubleQuotes},
+	"ps1":        {Args: []string{"pwsh", "-NoProfile", "-File"}, Extension: ".ps1", SecretReference: "$env:%s", SecretQuoting: quotingDoubleQuotes},
+	// The python reference imports os itself so it works in scripts that do not import it
+	"python":     {Args: []string{"python3"}, Extension: ".py", SecretReference: `__import__("os").environ["%s"]`},
+	"py":         {Args: []string{"python3"}, Extension: ".py", SecretReference: `__import__("os").environ["%s"]`},
+	"ruby":       {Args: []string{"ruby"}, Extension: ".rb", SecretReference: `ENV["%s"]`},
+	"perl":       {Args: []string{"perl"}, Extension: ".pl", SecretReference: `$ENV{"%s"}`},
+	"javascript": {Args: []string{"node"}, Extension: ".js", SecretReference: `process.env["%s"]`},
+	"js":         {Args: []string{"node"}, Extension: ".js", SecretReference: `process.env["%s"]`},
+}
+
+// interpreterFor returns the interpreter for the language, the second return value is false if commands
+// in the language can not be executed
+func interpreterFor(language string) (interpreter, bool) {
+	language = strings.ToLower(language)
+	defaultInterpreter, hasDefault := defaultInterpreters[language]
+
+	configured := GetValueNoError("interpreter-"+language, "")
+	if configured == "none" {
+		return interpreter{}, false
+	}
+	if configured != "" {
+		defaultInterpreter.Args = strings.Fields(configured)
+		return defaultInterpreter, true
+	}
+	if hasDefault {
+		return defaultInterpreter, true
+	}
+	// Languages we do not know about, like go or c, would fail or do something else when run with bash, they can be
+	// written to a file or run after configuring an interpreter for them
+	return interpreter{}, false
+}
@@ -0,0 +1,138 @@
+package main
+
+// This file contains the provider for the Anthropic messages api, the response is streamed as server sent events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type anthropicProvider struct {
+	model   string
+	apiKey  string
+	baseUrl string
+}
+
+// anthropicError is returned when the api responds with an error
+type anthropicError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *anthropicError) Error() string {
+	return fmt.Sprintf("anthropic api error %d (%s): %s", e.StatusCode, e.Type, e.Message)
+}
+
+func (p anthropicProvider) Name() string  { return "anthropic" }
+func (p anthropicProvider) Model() string { return p.model }
+
+func (p anthropicProvider) CreateCompletionStream(ctx context.Context, req completionRequest) (completionStream, error) {
+	type anthropicMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	body := struct {
+		Model     string             `json:"model"`
+		MaxTokens int                `json:"max_tokens"`
+		System    string             `json:"system,omitempty"`
+		Messages  []anthropicMessage `json:"messages"`
+		Stream    bool               `json:"stream"`
+	}{
+		Model:     p.model,
+		MaxTokens: req.MaxTokens,
+		Stream:    true,
+	}
+	// The system prompt is not a message in the anthropic api
+	for _, message := range req.Messages {
+		if message.Role == "system" {
+			body.System += message.Content
+			continue
+		}
+		body.Messages = append(body.Messages, anthropicMessage{Role: message.Role, Content: message.Content})
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseUrl+"/v1/messages", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("content-type", "application/json")
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, readAnthropicError(resp.StatusCode, resp.Body)
+	}
+	return &anthropicStream{body: resp.Body, scanner: bufio.NewScanner(resp.Body)}, nil
+}
+
+func readAnthropicError(statusCode int, body io.Reader) error {
+	var errorResponse struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(body).Decode(&errorResponse)
+	return &anthropicError{StatusCode: statusCode, Type: errorResponse.Error.Type, Message: errorResponse.Error.Message}
+}
+
+type anthropicStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+func (s *anthropicStream) Recv() (string, error) {
+	for s.scanner.Scan() {
+		data, isData := strings.CutPrefix(s.scanner.Text(), "data: ")
+		if !isData {
+			continue
+		}
+		var event struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Text string `json:"text"`
+			} `json:"delta"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		err := json.Unmarshal([]byte(data), &event)
+		if err != nil {
+			return "", err
+		}
+		switch event.Type {
+		case "content_block_delta":
+			return event.Delta.Text, nil
+		case "message_stop":
+			return "", io.EOF
+		case "error":
+			return "", &anthropicError{StatusCode: http.StatusOK, Type: event.Error.Type, Message: event.Error.Message}
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return "", err
+	}
+	// The connection was closed before message_stop, the response is incomplete and must not be cached
+	return "", io.ErrUnexpectedEOF
+}
+
+func (s *anthropicStream) Close() error {
+	return s.body.Close()
+}
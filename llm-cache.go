@@ -0,0 +1,162 @@
+package main
+
+// This file contains the response cache of "cwc ai", complete responses are stored by a hash of the provider,
+// model and all messages so the same request is answered from disk instead of being sent again.
+// The same files are used to record responses as fixtures and to replay them without network access:
+//
+//	CWC_AI_RECORD=<dir>  send every request and store the responses in dir
+//	CWC_AI_REPLAY=<dir>  only answer from the responses in dir, requests that were not recorded fail
+//
+// While recording or replaying the requests do not contain the commands of the index or the environment of the
+// machine, so fixtures recorded on one machine are replayed on every other one.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// aiNoCache is set by --no-cache, the cache is not read but fresh responses are still stored
+var aiNoCache bool
+
+type cachedResponse struct {
+	Provider string              `json:"provider"`
+	Model    string              `json:"model"`
+	Messages []completionMessage `json:"messages"`
+	Response string              `json:"response"`
+}
+
+// cachingProvider answers requests from the responses in dir and stores the responses of provider in it
+type cachingProvider struct {
+	provider completionProvider
+	dir      string
+	read     bool
+	write    bool
+}
+
+func getAiCachePath() (string, error) {
+	configPath, err := getUserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, "commands-wiki", "ai-cache"), nil
+}
+
+// withResponseCache wraps the provider with the cache, or with the record or replay mode when they are enabled
+func withResponseCache(provider completionProvider) (completionProvider, error) {
+	if dir := os.Getenv("CWC_AI_RECORD"); dir != "" {
+		return cachingProvider{provider: provider, dir: dir, write: true}, nil
+	}
+	if GetValueNoError("ai-cache", "true") == "false" {
+		return provider, nil
+	}
+	dir, err := getAiCachePath()
+	if err != nil {
+		return nil, err
+	}
+	return cachingProvider{provider: provider, dir: dir, read: !aiNoCache, write: true}, nil
+}
+
+// newReplayProvider answers from the recorded responses in dir only, the provider and model are taken from the
+// config so the requests match the recorded ones
+func newReplayProvider(dir string) (completionProvider, error) {
+	providerName, model, err := configuredProviderAndModel()
+	if err != nil {
+		return nil, err
+	}
+	return cachingProvider{provider: replayMissProvider{name: providerName, model: model, dir: dir}, dir: dir, read: true}, nil
+}
+
+func (p cachingProvider) Name() string  { return p.provider.Name() }
+func (p cachingProvider) Model() string { return p.provider.Model() }
+
+func (p cachingProvider) CreateCompletionStream(ctx context.Context, req completionRequest) (completionStream, error) {
+	path := filepath.Join(p.dir, p.cacheKey(req)+".json")
+	if p.read {
+		contents, err := os.ReadFile(path)
+		if err == nil {
+			var cached cachedResponse
+			if err := json.Unmarshal(contents, &cached); err == nil {
+				return &fakeStream{chunks: strings.SplitAfter(cached.Response, "\n")}, nil
+			}
+		}
+	}
+
+	stream, err := p.provider.CreateCompletionStream(ctx, req)
+	if err != nil || !p.write {
+		return stream, err
+	}
+	return &recordingStream{
+		stream: stream,
+		path:   path,
+		cached: cachedResponse{Provider: p.Name(), Model: p.Model(), Messages: req.Messages},
+	}, nil
+}
+
+// cacheKey is the hash of everything that changes the response
+func (p cachingProvider) cacheKey(req completionRequest) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%d\x00", p.Name(), p.Model(), req.MaxTokens)
+	for _, message := range req.Messages {
+		fmt.Fprintf(hash, "%s\x00%s\x00", message.Role, message.Content)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// recordingStream stores the response once it has been received completely
+type recordingStream struct {
+	stream completionStream
+	path   string
+	cached cachedResponse
+}
+
+func (s *recordingStream) Recv() (string, error) {
+	delta, err := s.stream.Recv()
+	s.cached.Response += delta
+	if errors.Is(err, io.EOF) {
+		// Failing to store the response should not fail the request, it is only sent again next time
+		_ = s.store()
+	}
+	return delta, err
+}
+
+func (s *recordingStream) Close() error { return s.stream.Close() }
+
+func (s *recordingStream) store() error {
+	contents, err := json.MarshalIndent(s.cached, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a cancelled request never leaves half a response behind
+	tmpPath := s.path + ".tmp"
+	err = os.WriteFile(tmpPath, contents, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// replayMissProvider is used when replaying, every request that reaches it was not recorded
+type replayMissProvider struct {
+	name  string
+	model string
+	dir   string
+}
+
+func (p replayMissProvider) Name() string  { return p.name }
+func (p replayMissProvider) Model() string { return p.model }
+
+func (p replayMissProvider) CreateCompletionStream(ctx context.Context, req completionRequest) (completionStream, error) {
+	return nil, fmt.Errorf("no recorded response for this request in %s, record it with CWC_AI_RECORD", p.dir)
+}
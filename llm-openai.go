@@ -0,0 +1,76 @@
+package main
+
+// This file contains the provider for OpenAI, Azure OpenAI and any api that is compatible with OpenAI
+// (Ollama, llama.cpp server, vLLM, ...)
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type openAiProvider struct {
+	name   string
+	model  string
+	client *openai.Client
+}
+
+func newOpenAiProvider(name string, model string, apiKey string, baseUrl string, isAzure bool) openAiProvider {
+	var config openai.ClientConfig
+	if isAzure {
+		config = openai.DefaultAzureConfig(apiKey, baseUrl)
+	} else {
+		config = openai.DefaultConfig(apiKey)
+		if baseUrl != "" {
+			config.BaseURL = baseUrl
+		}
+	}
+	return openAiProvider{
+		name:   name,
+		model:  model,
+		client: openai.NewClientWithConfig(config),
+	}
+}
+
+func (p openAiProvider) Name() string  { return p.name }
+func (p openAiProvider) Model() string { return p.model }
+
+func (p openAiProvider) CreateCompletionStream(ctx context.Context, req completionRequest) (completionStream, error) {
+	var messages []openai.ChatCompletionMessage
+	for _, message := range req.Messages {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+	}
+	stream, err := p.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:     p.model,
+		MaxTokens: req.MaxTokens,
+		Messages:  messages,
+		Stream:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return openAiStream{stream: stream}, nil
+}
+
+type openAiStream struct {
+	stream *openai.ChatCompletionStream
+}
+
+func (s openAiStream) Recv() (string, error) {
+	response, err := s.stream.Recv()
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", nil
+	}
+	return response.Choices[0].Delta.Content, nil
+}
+
+func (s openAiStream) Close() error {
+	s.stream.Close()
+	return nil
+}
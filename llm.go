@@ -0,0 +1,187 @@
+package main
+
+// This file contains the abstraction over the LLM providers used by "cwc ai"
+// The provider is selected in the config:
+//
+//	ai-provider     openai | azure | openai-compatible | ollama | anthropic | fake
+//	ai-model        the model (or azure deployment) to use
+//	ai-base-url     the base url of the api, required for azure and openai-compatible
+//	ai-api-key-env  the name of the environment variable holding the api key
+//	ai-timeout      the number of seconds to wait for a response
+//	ai-retries      how often a request is retried when the provider is rate limited or overloaded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+type completionMessage struct {
+	Role    string
+	Content string
+}
+
+type completionRequest struct {
+	MaxTokens int
+	Messages  []completionMessage
+}
+
+// completionStream returns the response of the model piece by piece, Recv returns io.EOF when the response is complete
+type completionStream interface {
+	Recv() (string, error)
+	Close() error
+}
+
+type completionProvider interface {
+	Name() string
+	Model() string
+	CreateCompletionStream(ctx context.Context, req completionRequest) (completionStream, error)
+}
+
+var defaultModels = map[string]string{
+	"openai":            "gpt-4-1106-preview",
+	"azure":             "gpt-4",
+	"openai-compatible": "llama3",
+	"ollama":            "llama3",
+	"anthropic":         "claude-3-5-sonnet-latest",
+	"fake":              "fake",
+}
+
+var defaultApiKeyEnvs = map[string]string{
+	"openai":    "OPENAI_API_KEY",
+	"azure":     "AZURE_OPENAI_API_KEY",
+	"anthropic": "ANTHROPIC_API_KEY",
+}
+
+// newCompletionProvider creates the provider configured in the config, with the response cache in front of it
+func newCompletionProvider() (completionProvider, error) {
+	if dir := os.Getenv("CWC_AI_REPLAY"); dir != "" {
+		return newReplayProvider(dir)
+	}
+	provider, err := newConfiguredProvider()
+	if err != nil {
+		return nil, err
+	}
+	return withResponseCache(provider)
+}
+
+// configuredProviderAndModel returns the names of the provider and model in the config
+func configuredProviderAndModel() (string, string, error) {
+	providerName := GetValueNoError("ai-provider", "openai")
+	defaultModel, ok := defaultModels[providerName]
+	if !ok {
+		return "", "", fmt.Errorf("unknown ai-provider %q", providerName)
+	}
+	// openai-model is the old name of ai-model
+	return providerName, GetValueNoError("ai-model", GetValueNoError("openai-model", defaultModel)), nil
+}
+
+func newConfiguredProvider() (completionProvider, error) {
+	providerName, model, err := configuredProviderAndModel()
+	if err != nil {
+		return nil, err
+	}
+	baseUrl := GetValueNoError("ai-base-url", "")
+
+	apiKeyEnv := GetValueNoError("ai-api-key-env", defaultApiKeyEnvs[providerName])
+	var apiKey string
+	if apiKeyEnv != "" {
+		apiKey = os.Getenv(apiKeyEnv)
+	}
+
+	switch providerName {
+	case "openai":
+		if apiKey == "" {
+			return nil, fmt.Errorf("%s is not set", apiKeyEnv)
+		}
+		return newOpenAiProvider(providerName, model, apiKey, baseUrl, false), nil
+	case "azure":
+		if apiKey == "" {
+			return nil, fmt.Errorf("%s is not set", apiKeyEnv)
+		}
+		if baseUrl == "" {
+			return nil, fmt.Errorf("ai-base-url has to be set to the azure openai endpoint")
+		}
+		return newOpenAiProvider(providerName, model, apiKey, baseUrl, true), nil
+	case "openai-compatible", "ollama":
+		if baseUrl == "" && providerName == "ollama" {
+			baseUrl = "http://localhost:11434/v1"
+		}
+		if baseUrl == "" {
+			return nil, fmt.Errorf("ai-base-url has to be set for openai-compatible providers")
+		}
+		return newOpenAiProvider(providerName, model, apiKey, baseUrl, false), nil
+	case "anthropic":
+		if apiKey == "" {
+			return nil, fmt.Errorf("%s is not set", apiKeyEnv)
+		}
+		if baseUrl == "" {
+			baseUrl = "https://api.anthropic.com"
+		}
+		return anthropicProvider{model: model, apiKey: apiKey, baseUrl: strings.TrimSuffix(baseUrl, "/")}, nil
+	case "fake":
+		response, err := os.ReadFile(GetValueNoError("ai-fake-response", ""))
+		if err != nil {
+			return nil, fmt.Errorf("the fake provider needs a response file in ai-fake-response: %w", err)
+		}
+		return fakeProvider{response: string(response)}, nil
+	}
+	return nil, fmt.Errorf("unknown ai-provider %q", providerName)
+}
+
+// isRetryableCompletionError returns true if the request failed because the provider is rate limited or
+// temporarily unavailable, sending it again later could work
+func isRetryableCompletionError(err error) bool {
+	var statusCode int
+	var apiError *openai.APIError
+	var requestError *openai.RequestError
+	var anthropicErr *anthropicError
+	switch {
+	case errors.As(err, &apiError):
+		statusCode = apiError.HTTPStatusCode
+	case errors.As(err, &requestError):
+		statusCode = requestError.HTTPStatusCode
+	case errors.As(err, &anthropicErr):
+		if anthropicErr.Type == "rate_limit_error" || anthropicErr.Type == "overloaded_error" {
+			return true
+		}
+		statusCode = anthropicErr.StatusCode
+	default:
+		return false
+	}
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// fakeProvider answers every request with the same response, it is used to test cwc without a real model
+type fakeProvider struct {
+	response string
+}
+
+func (p fakeProvider) Name() string  { return "fake" }
+func (p fakeProvider) Model() string { return "fake" }
+
+func (p fakeProvider) CreateCompletionStream(ctx context.Context, req completionRequest) (completionStream, error) {
+	// Split the response into lines to behave like a streaming model
+	return &fakeStream{chunks: strings.SplitAfter(p.response, "\n")}, nil
+}
+
+type fakeStream struct {
+	chunks []string
+}
+
+func (s *fakeStream) Recv() (string, error) {
+	if len(s.chunks) == 0 {
+		return "", io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *fakeStream) Close() error { return nil }
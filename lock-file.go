@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errLockHeld is returned by tryLockFile if another process holds the lock
+var errLockHeld = fmt.Errorf("the lock is held by another process")
+
+// acquireLockFile takes an exclusive advisory lock on the file at path, if another cwc holds it it waits up to wait
+// for it to be released. The operating system releases the lock when the process exits, so a crashed cwc never
+// leaves a lock behind. Call the returned function to release the lock.
+func acquireLockFile(path string, wait time.Duration) (func(), error) {
+	err := os.MkdirAll(filepath.Dir(path), 0744)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		err = tryLockFile(file)
+		if err == nil {
+			// The pid is only written to help finding the process that holds the lock
+			file.Truncate(0)
+			fmt.Fprintln(file, os.Getpid())
+			return func() {
+				unlockFile(file)
+				file.Close()
+			}, nil
+		}
+		if err != errLockHeld {
+			file.Close()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("%s is locked by another cwc", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// isLockFileHeld returns true if another process holds the lock on the file at path
+func isLockFileHeld(path string) bool {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	err = tryLockFile(file)
+	if err != nil {
+		return err == errLockHeld
+	}
+	unlockFile(file)
+	return false
+}
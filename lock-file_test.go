@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.lock")
+	if isLockFileHeld(path) {
+		t.Fatal("isLockFileHeld() = true before the lock was taken")
+	}
+
+	release, err := acquireLockFile(path, 0)
+	if err != nil {
+		t.Fatalf("acquireLockFile() error = %v", err)
+	}
+	if !isLockFileHeld(path) {
+		t.Error("isLockFileHeld() = false whilst the lock is held")
+	}
+	_, err = acquireLockFile(path, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("acquireLockFile() succeeded whilst the lock is held")
+	}
+
+	// The lock can be taken again once it is released, the file is left in place
+	release()
+	if isLockFileHeld(path) {
+		t.Error("isLockFileHeld() = true after the lock was released")
+	}
+	release, err = acquireLockFile(path, 0)
+	if err != nil {
+		t.Fatalf("acquireLockFile() after the release error = %v", err)
+	}
+	release()
+}
+
+func TestAcquireLockFileWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.lock")
+	release, err := acquireLockFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release()
+	}()
+	release, err = acquireLockFile(path, 5*time.Second)
+	if err != nil {
+		t.Fatalf("acquireLockFile() error = %v, want it to wait for the release", err)
+	}
+	release()
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/charmbracelet/log"
 	"github.com/google/go-github/v57/github"
@@ -25,15 +26,35 @@ func main() {
 	// updateIndex [--repo <repo>]
 	updateIndexCmd := flag.NewFlagSet("updateIndex", flag.ExitOnError)
 	updateIndexRepo := updateIndexCmd.String("repo", default_repo, "repo <repo>")
-	updateIndexBranch := updateIndexCmd.String("branch", getDefaultBranch(), "branch <branch>")
+	updateIndexBranch := updateIndexCmd.String("branch", getDefaultBranch(), "branch <branch>, the default branch of the repository if empty")
+	updateIndexPin := updateIndexCmd.String("pin", getPin(), "pin <tag or commit> to stay on instead of following the branch")
+	updateIndexContentRoot := updateIndexCmd.String("content-root", getContentRoot(), "content-root <directory with the commands>")
 
-	// search <term>
+	// search [--host <host>] <term>
 	searchCmd := flag.NewFlagSet("search", flag.ExitOnError)
+	searchCmd.StringVar(&targetHost, "host", GetValueNoError("host", ""), "host <user@server>")
+	searchCmd.StringVar(&outputMode, "output", GetValueNoError("output", "terminal"), "output <terminal|pane>")
 
-	// ai <prompt>
+	// explain [--placeholders=false] <command>
+	explainCmd := flag.NewFlagSet("explain", flag.ExitOnError)
+	explainPlaceholders := explainCmd.Bool("placeholders", true, "replace values in the command with variables")
+	explainCmd.StringVar(&targetHost, "host", GetValueNoError("host", ""), "host <user@server>")
+	explainCmd.StringVar(&outputMode, "output", GetValueNoError("output", "terminal"), "output <terminal|pane>")
+	explainCmd.BoolVar(&aiNoCache, "no-cache", false, "send the request even if the response is cached")
+
+	// ai [--host <host>] <prompt>
 	aiCmd := flag.NewFlagSet("ai", flag.ExitOnError)
+	aiCmd.StringVar(&targetHost, "host", GetValueNoError("host", ""), "host <user@server>")
+	aiCmd.StringVar(&outputMode, "output", GetValueNoError("output", "terminal"), "output <terminal|pane>")
+	aiCmd.BoolVar(&aiNoCache, "no-cache", false, "send the request even if the response is cached")
+
+	// doctor [--json]
+	doctorCmd := flag.NewFlagSet("doctor", flag.ExitOnError)
+	doctorJson := doctorCmd.Bool("json", false, "print the report as json, for example for bug reports")
 
 	if len(os.Args) < 2 {
+		targetHost = GetValueNoError("host", "")
+		outputMode = GetValueNoError("output", "terminal")
 		search("")
 		os.Exit(0)
 	}
@@ -41,33 +62,41 @@ func main() {
 	switch os.Args[1] {
 	case "update", "updateIndex":
 		updateIndexCmd.Parse(os.Args[2:])
-		err := updateIndex(*updateIndexRepo, *updateIndexBranch)
+		err := updateIndex(*updateIndexRepo, wikiRef{Branch: *updateIndexBranch, Pin: *updateIndexPin}, *updateIndexContentRoot)
 		if err != nil {
 			log.Fatal("an error ocurred whilst updating the index", "error", err)
 		}
+		flags := map[string]bool{}
+		updateIndexCmd.Visit(func(f *flag.Flag) { flags[f.Name] = true })
+		setIndexRef(wikiRef{Branch: *updateIndexBranch, Pin: *updateIndexPin}, flags)
 	case "s", "search":
-		searchCmd.Parse(os.Args[2:])
-		// Join all remaining arguments
-		query := ""
-		for _, arg := range searchCmd.Args() {
-			query += arg + " "
-		}
-		search(query)
+		search(strings.Join(parseFlagsUntilTerm(searchCmd, os.Args[2:]), " "))
+	case "show":
+		// show <id|link|searchterm>
+		showCommandByID(strings.Join(parseFlagsUntilTerm(searchCmd, os.Args[2:]), " "))
 	case "clean":
 		err := CleanConfig()
 		if err != nil {
 			log.Fatal("an error occurred whilst cleaning the config", "error", err)
 		}
+	case "doctor", "status":
+		doctorCmd.Parse(os.Args[2:])
+		runDoctor(*doctorJson)
 	case "version":
 		fmt.Println("cwc - commands.wiki in your terminal")
 		fmt.Println("Commit: " + sha)
 		fmt.Println("Branch: " + branch)
 		fmt.Println("Github: https://github.com/BL19/commands-wiki-cli")
+		fmt.Println("Index: " + indexVersion())
 		os.Exit(0)
 	case "ai":
+		// ai list|show|edit|rm|export|promote
+		if runAiManagementCommand(os.Args[2:]) {
+			os.Exit(0)
+		}
 		aiCmd.Parse(os.Args[2:])
 		// ai <prompt>
-		if len(os.Args) < 3 {
+		if aiCmd.NArg() < 1 {
 			log.Fatal("ai command requires a prompt")
 		}
 		query := ""
@@ -75,21 +104,81 @@ func main() {
 			query += arg + " "
 		}
 		runCommandAiCommandGeneration(query)
+	case "explain":
+		explainCmd.Parse(os.Args[2:])
+		// explain <command>
+		if explainCmd.NArg() < 1 {
+			log.Fatal("explain requires a command, for example: cwc explain 'tar -xzvf foo.tgz -C /opt'")
+		}
+		runCommandExplanation(strings.Join(explainCmd.Args(), " "), *explainPlaceholders)
 	default:
-		// Assume we are searching and try to search
-		// Join all remaining arguments
-		query := ""
-		for _, arg := range os.Args[1:] {
-			query += arg + " "
+		// Assume we are searching and try to search, links to commands open the command. Search terms can start
+		// with "-", like "cwc -rf", so only the flags of search before the first term are parsed.
+		terms := parseFlagsUntilTerm(searchCmd, os.Args[1:])
+		if len(terms) == 1 && isCommandLink(terms[0]) {
+			showCommandByID(terms[0])
+			break
 		}
-		search(query)
+		search(strings.Join(terms, " "))
 	}
 }
 
+// parseFlagsUntilTerm parses the flags of the flag set at the start of args and returns the rest of the arguments,
+// the first argument that is not a flag of the set, or the arguments after "--", start the terms
+func parseFlagsUntilTerm(flags *flag.FlagSet, args []string) []string {
+	end := 0
+	for end < len(args) {
+		arg := args[end]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name == "h" || name == "help" {
+			end++
+			continue
+		}
+		f := flags.Lookup(name)
+		if f == nil {
+			break
+		}
+		end++
+		if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); !hasValue && !(ok && boolFlag.IsBoolFlag()) {
+			// The value is the next argument
+			end++
+		}
+	}
+	flags.Parse(args[:min(end, len(args))])
+	terms := args[min(end, len(args)):]
+	if len(terms) > 0 && terms[0] == "--" {
+		terms = terms[1:]
+	}
+	return terms
+}
+
+// indexVersion describes the revision of the wiki the index was built from
+func indexVersion() string {
+	envelope, err := readIndexEnvelope()
+	if err != nil {
+		return "not built yet, run \"cwc update\""
+	}
+	version := envelope.Meta.Repo
+	if envelope.Meta.Commit != "" {
+		version += " at " + envelope.Meta.Commit + " (" + getIndexRef().String() + ")"
+	}
+	if !envelope.Meta.Created.IsZero() {
+		version += ", built " + envelope.Meta.Created.Format("2006-01-02 15:04")
+	}
+	return version
+}
+
+// checkIfUpdate asks to install a newer cwc, it is skipped in background updates and when nobody can answer
 func checkIfUpdate() {
 	if branch == "local" || sha == "local" {
 		return
 	}
+	if os.Getenv("CWC_BACKGROUND") == "1" || !isTerminal(os.Stdin) {
+		return
+	}
 
 	client := github.NewClient(nil)
 	commits, _, err := client.Repositories.ListCommits(context.Background(), "BL19", "commands-wiki-cli", &github.CommitsListOptions{
@@ -119,10 +208,10 @@ func checkIfUpdate() {
 		fmt.Println()
 		fmt.Println("Compare changes: https://github.com/BL19/commands-wiki-cli/compare/" + sha + "..." + *newCommits[0].SHA)
 		fmt.Println()
-		fmt.Print("Do you want to update (Y/n)? ")
+		fmt.Print("Do you want to update (y/N)? ")
 		var input string
-		fmt.Scanln(&input)
-		if input == "Y" || input == "y" || input == "" {
+		_, err := fmt.Scanln(&input)
+		if err == nil && (input == "Y" || input == "y") {
 			log.Info("Updating...")
 			// Write a temp update script
 			updateScriptPath := "/tmp/" + uuid.NewV4().String() + ".sh"
@@ -144,3 +233,8 @@ func checkIfUpdate() {
 		}
 	}
 }
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
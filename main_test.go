@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseFlagsUntilTerm(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantHost  string
+		wantPane  bool
+		wantTerms []string
+	}{
+		{name: "only terms", args: []string{"disk", "usage"}, wantTerms: []string{"disk", "usage"}},
+		{name: "term starting with a dash", args: []string{"-rf"}, wantTerms: []string{"-rf"}},
+		{name: "flag before the terms", args: []string{"--host", "me@server", "-rf", "--host"}, wantHost: "me@server", wantTerms: []string{"-rf", "--host"}},
+		{name: "flag with a value", args: []string{"-host=me@server", "disk"}, wantHost: "me@server", wantTerms: []string{"disk"}},
+		{name: "bool flag", args: []string{"--pane", "disk"}, wantPane: true, wantTerms: []string{"disk"}},
+		{name: "terms after a double dash", args: []string{"--pane", "--", "--host", "x"}, wantPane: true, wantTerms: []string{"--host", "x"}},
+		{name: "no arguments", args: []string{}, wantTerms: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("search", flag.ContinueOnError)
+			host := flags.String("host", "", "")
+			pane := flags.Bool("pane", false, "")
+			terms := parseFlagsUntilTerm(flags, test.args)
+			if *host != test.wantHost || *pane != test.wantPane {
+				t.Errorf("host = %q, pane = %v, want %q, %v", *host, *pane, test.wantHost, test.wantPane)
+			}
+			if !reflect.DeepEqual(terms, test.wantTerms) {
+				t.Errorf("terms = %q, want %q", terms, test.wantTerms)
+			}
+		})
+	}
+}
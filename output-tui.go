@@ -0,0 +1,393 @@
+package main
+
+// This file contains the output pane, it runs a command under a pty and shows the output inside of the TUI
+// so the documentation stays around and the command can be edited and run again
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/log"
+	"github.com/creack/pty"
+)
+
+// outputMode is either "terminal" (the command takes over the terminal) or "pane" (the output is shown in the TUI)
+// It is set with the --output flag or the "output" config key
+var outputMode string
+
+type outputAction int
+
+const (
+	outputActionNone outputAction = iota
+	outputActionRerun
+	outputActionEdit
+)
+
+type outputChunkMsg []byte
+type outputClosedMsg struct{}
+type outputExitedMsg struct {
+	exitCode int
+	err      error
+}
+
+type outputModel struct {
+	title     string
+	host      string
+	process   *exec.Cmd
+	pty       *os.File
+	output    []byte
+	viewport  viewport.Model
+	keys      outputKeymap
+	help      help.Model
+	textInput textinput.Model
+	isSaving  bool
+	started   time.Time
+	duration  time.Duration
+	exitCode  int
+	isDone    bool
+	err       error
+	status    string
+	action    outputAction
+}
+
+type outputKeymap struct {
+	Rerun key.Binding
+	Edit  key.Binding
+	Save  key.Binding
+	Quit  key.Binding
+}
+
+// ShortHelp returns keybindings to be shown in the mini help view. It's part
+// of the key.Map interface.
+func (k outputKeymap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Rerun, k.Edit, k.Save, k.Quit}
+}
+
+// FullHelp returns keybindings for the expanded help view. It's part of the
+// key.Map interface.
+func (k outputKeymap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Rerun, k.Edit}, // first column
+		{k.Save, k.Quit},  // second column
+	}
+}
+
+var OutputKeymap = outputKeymap{
+	Rerun: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "run again"),
+	),
+	Edit: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("e", "edit variables and run again"),
+	),
+	Save: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "save output"),
+	),
+	Quit: key.NewBinding(
+		key.WithKeys("q", "ctrl+c", "esc"),
+		key.WithHelp("q", "quit"),
+	),
+}
+
+// runInOutputPane runs the process in the output pane, it returns what the user wants to do next and the exit code
+func runInOutputPane(title string, host string, process *exec.Cmd) (outputAction, int, error) {
+	ti := textinput.New()
+	ti.Focus()
+	ti.CharLimit = 250
+	ti.Width = 60
+
+	m := outputModel{
+		title:     title,
+		host:      host,
+		process:   process,
+		viewport:  viewport.New(0, 0),
+		keys:      OutputKeymap,
+		help:      help.New(),
+		textInput: ti,
+	}
+
+	model, err := tea.NewProgram(m, tea.WithAltScreen()).Run()
+	if err != nil {
+		// Do not leave the command running without anyone seeing its output
+		if process.Process != nil {
+			process.Process.Kill()
+		}
+		return outputActionNone, -1, err
+	}
+	m = model.(outputModel)
+	if m.err != nil {
+		log.Error("failed to run the command", "error", m.err)
+	}
+	return m.action, m.exitCode, nil
+}
+
+func (m outputModel) Init() tea.Cmd {
+	return startOutputProcess(m.process)
+}
+
+type outputStartedMsg struct {
+	pty     *os.File
+	started time.Time
+}
+
+func startOutputProcess(process *exec.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		process.Dir = os.TempDir()
+		f, err := pty.Start(process)
+		if err != nil {
+			return outputExitedMsg{exitCode: -1, err: err}
+		}
+		return outputStartedMsg{pty: f, started: time.Now()}
+	}
+}
+
+// readOutput reads the next chunk of output from the pty
+func readOutput(f *os.File) tea.Cmd {
+	return func() tea.Msg {
+		buf := make([]byte, 4096)
+		n, err := f.Read(buf)
+		if n > 0 {
+			return outputChunkMsg(buf[:n])
+		}
+		if err != nil {
+			return outputClosedMsg{}
+		}
+		return outputChunkMsg(nil)
+	}
+}
+
+func waitForProcess(process *exec.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		err := process.Wait()
+		if _, isExitError := err.(*exec.ExitError); isExitError {
+			err = nil
+		}
+		return outputExitedMsg{exitCode: process.ProcessState.ExitCode(), err: err}
+	}
+}
+
+func (m outputModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.viewport.Width = msg.Width
+		m.viewport.Height = msg.Height - 4
+		if m.pty != nil {
+			pty.Setsize(m.pty, &pty.Winsize{Rows: uint16(m.viewport.Height), Cols: uint16(m.viewport.Width)})
+		}
+		m.viewport.SetContent(renderOutput(m.output))
+		return m, nil
+	case outputStartedMsg:
+		m.pty = msg.pty
+		m.started = msg.started
+		if m.viewport.Width > 0 {
+			pty.Setsize(m.pty, &pty.Winsize{Rows: uint16(m.viewport.Height), Cols: uint16(m.viewport.Width)})
+		}
+		return m, readOutput(m.pty)
+	case outputChunkMsg:
+		m.output = append(m.output, msg...)
+		atBottom := m.viewport.AtBottom()
+		m.viewport.SetContent(renderOutput(m.output))
+		if atBottom {
+			m.viewport.GotoBottom()
+		}
+		return m, readOutput(m.pty)
+	case outputClosedMsg:
+		return m, waitForProcess(m.process)
+	case outputExitedMsg:
+		if m.pty != nil {
+			m.pty.Close()
+		}
+		m.isDone = true
+		m.exitCode = msg.exitCode
+		m.err = msg.err
+		if !m.started.IsZero() {
+			m.duration = time.Since(m.started)
+		}
+		return m, nil
+	case tea.KeyMsg:
+		if !m.isDone {
+			// The command is still running, shift+up and shift+down scroll the output, all other keys are sent to it
+			switch msg.Type {
+			case tea.KeyShiftUp:
+				m.viewport.LineUp(1)
+				return m, nil
+			case tea.KeyShiftDown:
+				m.viewport.LineDown(1)
+				return m, nil
+			}
+			if input := keyToTerminalInput(msg); input != "" && m.pty != nil {
+				m.pty.WriteString(input)
+				return m, nil
+			}
+			break
+		}
+		if m.isSaving {
+			return m.updateSaving(msg)
+		}
+		m.status = ""
+		switch {
+		case key.Matches(msg, m.keys.Rerun):
+			m.action = outputActionRerun
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Edit):
+			m.action = outputActionEdit
+			return m, tea.Quit
+		case key.Matches(msg, m.keys.Save):
+			m.isSaving = true
+			m.status = ""
+			m.textInput.SetValue("")
+			m.textInput.Placeholder = "output.log"
+			return m, nil
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		}
+	}
+
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+// updateSaving handles the key presses whilst the user enters the file to save the output to
+func (m outputModel) updateSaving(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.isSaving = false
+		return m, nil
+	case tea.KeyEnter:
+		filePath := m.textInput.Value()
+		if filePath == "" {
+			filePath = m.textInput.Placeholder
+		}
+		err := os.WriteFile(filePath, []byte(ansiEscapeRegex.ReplaceAllString(renderOutput(m.output), "")), 0644)
+		if err != nil {
+			m.status = "Failed to save the output: " + err.Error()
+		} else {
+			m.status = "Saved the output to " + filePath
+		}
+		m.isSaving = false
+		return m, nil
+	}
+	m.textInput, cmd = m.textInput.Update(msg)
+	return m, cmd
+}
+
+// terminalKeySequences are the escape sequences a terminal sends for the keys that are not a character
+var terminalKeySequences = map[tea.KeyType]string{
+	tea.KeyUp:             "\x1b[A",
+	tea.KeyDown:           "\x1b[B",
+	tea.KeyRight:          "\x1b[C",
+	tea.KeyLeft:           "\x1b[D",
+	tea.KeyShiftRight:     "\x1b[1;2C",
+	tea.KeyShiftLeft:      "\x1b[1;2D",
+	tea.KeyCtrlUp:         "\x1b[1;5A",
+	tea.KeyCtrlDown:       "\x1b[1;5B",
+	tea.KeyCtrlRight:      "\x1b[1;5C",
+	tea.KeyCtrlLeft:       "\x1b[1;5D",
+	tea.KeyCtrlShiftUp:    "\x1b[1;6A",
+	tea.KeyCtrlShiftDown:  "\x1b[1;6B",
+	tea.KeyCtrlShiftRight: "\x1b[1;6C",
+	tea.KeyCtrlShiftLeft:  "\x1b[1;6D",
+	tea.KeyHome:           "\x1b[H",
+	tea.KeyEnd:            "\x1b[F",
+	tea.KeyShiftHome:      "\x1b[1;2H",
+	tea.KeyShiftEnd:       "\x1b[1;2F",
+	tea.KeyCtrlHome:       "\x1b[1;5H",
+	tea.KeyCtrlEnd:        "\x1b[1;5F",
+	tea.KeyCtrlShiftHome:  "\x1b[1;6H",
+	tea.KeyCtrlShiftEnd:   "\x1b[1;6F",
+	tea.KeyPgUp:           "\x1b[5~",
+	tea.KeyPgDown:         "\x1b[6~",
+	tea.KeyInsert:         "\x1b[2~",
+	tea.KeyDelete:         "\x1b[3~",
+	tea.KeyShiftTab:       "\x1b[Z",
+	tea.KeyF1:             "\x1bOP",
+	tea.KeyF2:             "\x1bOQ",
+	tea.KeyF3:             "\x1bOR",
+	tea.KeyF4:             "\x1bOS",
+	tea.KeyF5:             "\x1b[15~",
+	tea.KeyF6:             "\x1b[17~",
+	tea.KeyF7:             "\x1b[18~",
+	tea.KeyF8:             "\x1b[19~",
+	tea.KeyF9:             "\x1b[20~",
+	tea.KeyF10:            "\x1b[21~",
+	tea.KeyF11:            "\x1b[23~",
+	tea.KeyF12:            "\x1b[24~",
+}
+
+// keyToTerminalInput converts a key press to the bytes a terminal would send for it
+func keyToTerminalInput(msg tea.KeyMsg) string {
+	var input string
+	switch {
+	case msg.Type == tea.KeyRunes:
+		input = string(msg.Runes)
+	case msg.Type == tea.KeySpace:
+		input = " "
+	case msg.Type >= tea.KeyNull && msg.Type <= tea.KeyCtrlUnderscore, msg.Type == tea.KeyBackspace:
+		// The control keys are the ascii control characters, like 0x03 for ctrl+c and 0x0d for enter
+		input = string(rune(msg.Type))
+	default:
+		input = terminalKeySequences[msg.Type]
+	}
+	// Alt is sent as an escape in front of the key
+	if msg.Alt && input != "" {
+		input = "\x1b" + input
+	}
+	return input
+}
+
+var ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// renderOutput turns the raw pty output into lines, carriage returns (used by progress bars) overwrite the line
+func renderOutput(output []byte) string {
+	lines := strings.Split(strings.ReplaceAll(string(output), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		if index := strings.LastIndex(line, "\r"); index >= 0 {
+			lines[i] = line[index+1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m outputModel) View() string {
+	var header string
+	if m.host == "local" {
+		header = titleStyle.Render(m.title)
+	} else {
+		header = titleStyle.Render(m.title + " @ " + m.host)
+	}
+	if m.isDone {
+		if m.exitCode == 0 {
+			header += " " + statusMessageStyle(fmt.Sprintf("exited with 0 after %s", m.duration.Round(time.Millisecond)))
+		} else {
+			header += " " + execErrorStyle.Render(fmt.Sprintf("exited with %d after %s", m.exitCode, m.duration.Round(time.Millisecond)))
+		}
+	} else {
+		header += " running..."
+	}
+
+	view := header + "\n\n" + m.viewport.View() + "\n"
+	if m.isSaving {
+		view += "Save the output to: " + m.textInput.View()
+	} else if m.status != "" {
+		view += m.status
+	} else if m.isDone {
+		view += m.help.View(m.keys)
+	}
+	return view
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestKeyToTerminalInput(t *testing.T) {
+	tests := []struct {
+		name string
+		key  tea.KeyMsg
+		want string
+	}{
+		{name: "runes", key: tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("yé")}, want: "yé"},
+		{name: "space", key: tea.KeyMsg{Type: tea.KeySpace}, want: " "},
+		{name: "enter", key: tea.KeyMsg{Type: tea.KeyEnter}, want: "\r"},
+		{name: "tab", key: tea.KeyMsg{Type: tea.KeyTab}, want: "\t"},
+		{name: "backspace", key: tea.KeyMsg{Type: tea.KeyBackspace}, want: "\x7f"},
+		{name: "escape", key: tea.KeyMsg{Type: tea.KeyEsc}, want: "\x1b"},
+		{name: "ctrl+a", key: tea.KeyMsg{Type: tea.KeyCtrlA}, want: "\x01"},
+		{name: "ctrl+z", key: tea.KeyMsg{Type: tea.KeyCtrlZ}, want: "\x1a"},
+		{name: "up", key: tea.KeyMsg{Type: tea.KeyUp}, want: "\x1b[A"},
+		{name: "left", key: tea.KeyMsg{Type: tea.KeyLeft}, want: "\x1b[D"},
+		{name: "home", key: tea.KeyMsg{Type: tea.KeyHome}, want: "\x1b[H"},
+		{name: "end", key: tea.KeyMsg{Type: tea.KeyEnd}, want: "\x1b[F"},
+		{name: "page up", key: tea.KeyMsg{Type: tea.KeyPgUp}, want: "\x1b[5~"},
+		{name: "page down", key: tea.KeyMsg{Type: tea.KeyPgDown}, want: "\x1b[6~"},
+		{name: "delete", key: tea.KeyMsg{Type: tea.KeyDelete}, want: "\x1b[3~"},
+		{name: "f1", key: tea.KeyMsg{Type: tea.KeyF1}, want: "\x1bOP"},
+		{name: "alt+b", key: tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("b"), Alt: true}, want: "\x1bb"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := keyToTerminalInput(test.key); got != test.want {
+				t.Errorf("keyToTerminalInput() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+// This file contains the syncing of the wiki repository, it uses go-git so git does not have to be installed.
+// The clone is shallow by default, its depth can be changed in the config:
+//
+//	clone-depth  the number of commits to fetch, 0 fetches the full history (1 by default)
+//	pin          a tag or full commit hash to stay on instead of following the branch
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// wikiRef is what the wiki is synced to, a branch that is followed or a tag or commit the wiki is pinned to
+type wikiRef struct {
+	// Branch is followed when the wiki is not pinned, the default branch of the repository is used if it is empty
+	Branch string
+	// Pin is a tag or a full commit hash
+	Pin string
+}
+
+func (r wikiRef) String() string {
+	switch {
+	case r.Pin != "":
+		return r.Pin
+	case r.Branch != "":
+		return r.Branch
+	}
+	return "the default branch"
+}
+
+// RepoSyncer keeps the local clone of a repository up to date with a branch, tag or commit of the remote
+type RepoSyncer interface {
+	// Sync clones or updates the repository at path and checks out the ref, it returns the checked out commit
+	Sync(ctx context.Context, repo string, path string, ref wikiRef, progress io.Writer) (string, error)
+}
+
+type repoSyncErrorKind int
+
+const (
+	repoSyncFailed repoSyncErrorKind = iota
+	repoSyncAuth
+	repoSyncNetwork
+	repoSyncNotFound
+	repoSyncDiverged
+	repoSyncLocalChanges
+	repoSyncUnverified
+)
+
+// RepoSyncError explains why a repository could not be synced and what can be done about it
+type RepoSyncError struct {
+	Kind repoSyncErrorKind
+	Repo string
+	Ref  string
+	Err  error
+}
+
+func (e *RepoSyncError) Error() string {
+	switch e.Kind {
+	case repoSyncAuth:
+		return fmt.Sprintf("authentication for %s failed, check your ssh agent or credentials: %v", e.Repo, e.Err)
+	case repoSyncNetwork:
+		return fmt.Sprintf("could not reach %s, check your network connection: %v", e.Repo, e.Err)
+	case repoSyncNotFound:
+		return fmt.Sprintf("the repository %s or its branch, tag or commit %s does not exist: %v", e.Repo, e.Ref, e.Err)
+	case repoSyncDiverged:
+		return fmt.Sprintf("the local branch %s has commits that are not in %s, run \"cwc clean\" or reset the branch to update it", e.Ref, e.Repo)
+	case repoSyncLocalChanges:
+		return fmt.Sprintf("the local clone of %s has uncommitted changes, commit or remove them to update it", e.Repo)
+	case repoSyncUnverified:
+		return fmt.Sprintf("refusing to update to %s of %s: %v", e.Ref, e.Repo, e.Err)
+	}
+	return fmt.Sprintf("failed to sync %s: %v", e.Repo, e.Err)
+}
+
+func (e *RepoSyncError) Unwrap() error { return e.Err }
+
+type goGitSyncer struct {
+	depth int
+}
+
+func newRepoSyncer() RepoSyncer {
+	depth, err := strconv.Atoi(GetValueNoError("clone-depth", "1"))
+	if err != nil || depth < 0 {
+		depth = 1
+	}
+	return goGitSyncer{depth: depth}
+}
+
+func (s goGitSyncer) Sync(ctx context.Context, repo string, path string, ref wikiRef, progress io.Writer) (revision string, err error) {
+	var r *git.Repository
+	if _, statErr := os.Stat(path); statErr != nil {
+		// Do not leave a half cloned repository behind, the next update would try to use it
+		defer func() {
+			if err != nil {
+				os.RemoveAll(path)
+			}
+		}()
+		r, err = git.PlainInit(path, false)
+		if err == nil {
+			_, err = r.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{repo}})
+		}
+	} else {
+		r, err = git.PlainOpen(path)
+	}
+	if err != nil {
+		return "", newRepoSyncError(repo, ref.String(), err)
+	}
+
+	// Never guess the branch, a wiki without "master" would silently stop updating
+	if ref.Pin == "" && ref.Branch == "" {
+		ref.Branch, err = remoteDefaultBranch(ctx, repo)
+		if err != nil {
+			return "", newRepoSyncError(repo, ref.String(), err)
+		}
+	}
+
+	var commit, tag plumbing.Hash
+	if ref.Pin != "" {
+		commit, tag, err = s.fetchPin(ctx, r, repo, ref.Pin, progress)
+	} else {
+		commit, err = s.fetchBranch(ctx, r, repo, ref.Branch, progress)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Nothing is checked out before the signatures are verified
+	err = verifyRevision(r, commit, tag)
+	if err != nil {
+		return "", &RepoSyncError{Kind: repoSyncUnverified, Repo: repo, Ref: ref.String(), Err: err}
+	}
+
+	worktree, err := r.Worktree()
+	if err != nil {
+		return "", newRepoSyncError(repo, ref.String(), err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return "", newRepoSyncError(repo, ref.String(), err)
+	}
+	for _, fileStatus := range status {
+		if fileStatus.Worktree != git.Untracked || fileStatus.Staging != git.Untracked {
+			return "", &RepoSyncError{Kind: repoSyncLocalChanges, Repo: repo, Ref: ref.String()}
+		}
+	}
+
+	if ref.Pin != "" {
+		err = worktree.Checkout(&git.CheckoutOptions{Hash: commit, Force: true})
+	} else {
+		branchRef := plumbing.NewBranchReferenceName(ref.Branch)
+		err = r.Storer.SetReference(plumbing.NewHashReference(branchRef, commit))
+		if err == nil {
+			err = worktree.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true})
+		}
+	}
+	if err != nil {
+		return "", newRepoSyncError(repo, ref.String(), err)
+	}
+	return commit.String(), nil
+}
+
+// fetchBranch fetches the branch and returns its commit
+func (s goGitSyncer) fetchBranch(ctx context.Context, r *git.Repository, repo string, branch string, progress io.Writer) (plumbing.Hash, error) {
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	remoteRef := plumbing.NewRemoteReferenceName("origin", branch)
+
+	// The commit of the remote branch we synced last time, if the local branch is still there it has no
+	// commits of its own and can be moved to the new commit of the remote
+	var lastRemoteHash plumbing.Hash
+	if ref, err := r.Reference(remoteRef, true); err == nil {
+		lastRemoteHash = ref.Hash()
+	}
+
+	// Only fetch the branch we need, the clone may have been made for another branch
+	err := r.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RemoteURL:  repo,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+%s:%s", branchRef, remoteRef))},
+		Depth:      s.depth,
+		Progress:   progress,
+		Force:      true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return plumbing.ZeroHash, newRepoSyncError(repo, branch, err)
+	}
+	remote, err := r.Reference(remoteRef, true)
+	if err != nil {
+		return plumbing.ZeroHash, newRepoSyncError(repo, branch, err)
+	}
+
+	if local, err := r.Reference(branchRef, true); err == nil && local.Hash() != remote.Hash() && local.Hash() != lastRemoteHash {
+		if !isAncestorCommit(r, local.Hash(), remote.Hash()) {
+			return plumbing.ZeroHash, &RepoSyncError{Kind: repoSyncDiverged, Repo: repo, Ref: branch}
+		}
+	}
+	return remote.Hash(), nil
+}
+
+// fetchPin fetches the tag or commit the wiki is pinned to, it returns the commit and the tag object for
+// annotated tags
+func (s goGitSyncer) fetchPin(ctx context.Context, r *git.Repository, repo string, pin string, progress io.Writer) (plumbing.Hash, plumbing.Hash, error) {
+	if isCommitHash(pin) {
+		commit := plumbing.NewHash(pin)
+		// A pinned commit never changes, it only has to be fetched once
+		if _, err := r.CommitObject(commit); err == nil {
+			return commit, plumbing.ZeroHash, nil
+		}
+		err := r.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: "origin",
+			RemoteURL:  repo,
+			RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(pin + ":refs/cwc/pinned")},
+			Depth:      s.depth,
+			Progress:   progress,
+		})
+		// Servers that do not allow fetching a commit directly have to send all of the history
+		if errors.Is(err, git.ErrExactSHA1NotSupported) {
+			err = r.FetchContext(ctx, &git.FetchOptions{
+				RemoteName: "origin",
+				RemoteURL:  repo,
+				RefSpecs:   []gitconfig.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+				Progress:   progress,
+				Tags:       git.AllTags,
+			})
+		}
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return plumbing.ZeroHash, plumbing.ZeroHash, newRepoSyncError(repo, pin, err)
+		}
+		if _, err := r.CommitObject(commit); err != nil {
+			return plumbing.ZeroHash, plumbing.ZeroHash, newRepoSyncError(repo, pin, err)
+		}
+		return commit, plumbing.ZeroHash, nil
+	}
+
+	tagRef := plumbing.NewTagReferenceName(pin)
+	var previousTag plumbing.Hash
+	if ref, err := r.Reference(tagRef, false); err == nil {
+		previousTag = ref.Hash()
+	}
+	// The tag is fetched next to the local one so a moved tag is noticed on every update, not only the first
+	fetchedRef := plumbing.ReferenceName("refs/cwc/fetched-tag")
+	err := r.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RemoteURL:  repo,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+%s:%s", tagRef, fetchedRef))},
+		Depth:      s.depth,
+		Progress:   progress,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return plumbing.ZeroHash, plumbing.ZeroHash, newRepoSyncError(repo, pin, err)
+	}
+	ref, err := r.Reference(fetchedRef, false)
+	if err != nil {
+		return plumbing.ZeroHash, plumbing.ZeroHash, newRepoSyncError(repo, pin, err)
+	}
+	// A tag that points somewhere else than last time is most likely not the release that was pinned
+	if !previousTag.IsZero() && previousTag != ref.Hash() {
+		clonePath := "the clone of the wiki"
+		if worktree, err := r.Worktree(); err == nil {
+			clonePath = worktree.Filesystem.Root()
+		}
+		err := fmt.Errorf("the tag was moved from %s to %s, run \"git -C %s tag -d %s\" if that is expected", previousTag, ref.Hash(), clonePath, pin)
+		return plumbing.ZeroHash, plumbing.ZeroHash, &RepoSyncError{Kind: repoSyncUnverified, Repo: repo, Ref: pin, Err: err}
+	}
+	err = r.Storer.SetReference(plumbing.NewHashReference(tagRef, ref.Hash()))
+	if err != nil {
+		return plumbing.ZeroHash, plumbing.ZeroHash, newRepoSyncError(repo, pin, err)
+	}
+	if tag, err := r.TagObject(ref.Hash()); err == nil {
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, plumbing.ZeroHash, newRepoSyncError(repo, pin, err)
+		}
+		return commit.Hash, tag.Hash, nil
+	}
+	return ref.Hash(), plumbing.ZeroHash, nil
+}
+
+// remoteDefaultBranch returns the branch the HEAD of the remote points to
+func remoteDefaultBranch(ctx context.Context, repo string) (string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{repo}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference && ref.Target().IsBranch() {
+			return ref.Target().Short(), nil
+		}
+	}
+	return "", fmt.Errorf("the default branch of %s could not be found, set it with \"cwc update --branch <branch>\"", repo)
+}
+
+// isCommitHash returns true for full commit hashes, tags can look like short hashes so those are not allowed
+func isCommitHash(pin string) bool {
+	if len(pin) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(pin)
+	return err == nil
+}
+
+// isAncestorCommit returns true if ancestor is in the history of commit, it returns false if that can not be
+// determined because the history is shallow
+func isAncestorCommit(r *git.Repository, ancestor plumbing.Hash, commit plumbing.Hash) bool {
+	ancestorCommit, err := r.CommitObject(ancestor)
+	if err != nil {
+		return false
+	}
+	descendantCommit, err := r.CommitObject(commit)
+	if err != nil {
+		return false
+	}
+	isAncestor, err := ancestorCommit.IsAncestor(descendantCommit)
+	return err == nil && isAncestor
+}
+
+// newRepoSyncError finds out what kind of error err is
+func newRepoSyncError(repo string, ref string, err error) error {
+	kind := repoSyncFailed
+	var netErr net.Error
+	var refSpecErr git.NoMatchingRefSpecError
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed), errors.Is(err, transport.ErrInvalidAuthMethod):
+		kind = repoSyncAuth
+	case errors.Is(err, transport.ErrRepositoryNotFound), errors.Is(err, plumbing.ErrReferenceNotFound), errors.As(err, &refSpecErr):
+		kind = repoSyncNotFound
+	case errors.Is(err, git.ErrNonFastForwardUpdate):
+		kind = repoSyncDiverged
+	case errors.As(err, &netErr):
+		kind = repoSyncNetwork
+	}
+	return &RepoSyncError{Kind: kind, Repo: repo, Ref: ref, Err: err}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// testRemote is a bare repository the syncer clones from, commits are made in a separate work tree and pushed
+type testRemote struct {
+	t    *testing.T
+	url  string
+	work *git.Repository
+	// signKey signs the pushed commits if it is set
+	signKey *openpgp.Entity
+}
+
+func newTestRemote(t *testing.T) *testRemote {
+	// Signatures are only verified when trusted keys are configured, make sure the user's config is not used
+	t.Setenv("CWC_CONFIG_DIR", t.TempDir())
+
+	// Like a real remote the path ends with ".git", so it is synced as a git repository and not as a directory
+	bareDir := filepath.Join(t.TempDir(), "wiki.git")
+	bare, err := git.PlainInit(bareDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bare.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	work, err := git.PlainInit(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = work.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{bareDir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRemote{t: t, url: bareDir, work: work}
+}
+
+// commitFile writes the file on the branch of the work tree and returns the commit
+func commitFile(t *testing.T, r *git.Repository, branch string, name string, contents string) plumbing.Hash {
+	return signedCommitFile(t, r, branch, name, contents, nil)
+}
+
+// signedCommitFile is commitFile with a commit that is signed by signKey, or not signed if it is nil
+func signedCommitFile(t *testing.T, r *git.Repository, branch string, name string, contents string, signKey *openpgp.Entity) plumbing.Hash {
+	worktree, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if head, err := r.Head(); err == nil && head.Name() != branchRef {
+		err = worktree.Checkout(&git.CheckoutOptions{Branch: branchRef, Create: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else if err != nil {
+		// The first commit of the repository, HEAD points to a branch that does not exist yet
+		err = r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(worktree.Filesystem.Root(), filepath.FromSlash(name))
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = worktree.Add(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := worktree.Commit("Update "+name, &git.CommitOptions{
+		Author:  &object.Signature{Name: "cwc", Email: "cwc@example.com", When: time.Now()},
+		SignKey: signKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// push commits the file on the branch and pushes it to the remote
+func (r *testRemote) push(branch string, name string, contents string) plumbing.Hash {
+	hash := signedCommitFile(r.t, r.work, branch, name, contents, r.signKey)
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	err := r.work.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(branchRef + ":" + branchRef)},
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return hash
+}
+
+// tag points the tag to the commit and pushes it, the tag is annotated if message is not empty
+func (r *testRemote) tag(name string, commit plumbing.Hash, message string, signKey *openpgp.Entity) {
+	tagRef := plumbing.NewTagReferenceName(name)
+	err := r.work.Storer.RemoveReference(tagRef)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	var opts *git.CreateTagOptions
+	if message != "" {
+		opts = &git.CreateTagOptions{
+			Tagger:  &object.Signature{Name: "cwc", Email: "cwc@example.com", When: time.Now()},
+			Message: message,
+			SignKey: signKey,
+		}
+	}
+	_, err = r.work.CreateTag(name, commit, opts)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	err = r.work.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec("+" + tagRef + ":" + tagRef)},
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// newSignKey returns a new GPG key to sign commits and tags with
+func newSignKey(t *testing.T, name string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+// setTestConfig writes the config lines to the config directory of the test
+func setTestConfig(t *testing.T, config string) {
+	configPath := filepath.Join(os.Getenv("CWC_CONFIG_DIR"), "commands-wiki", "config")
+	err := os.MkdirAll(filepath.Dir(configPath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(configPath, []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// trustGPGKeys writes the public keys to a file and sets it as trusted-gpg-keys
+func trustGPGKeys(t *testing.T, keys ...*openpgp.Entity) {
+	var keyRing bytes.Buffer
+	writer, err := armor.Encode(&keyRing, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		err = key.Serialize(writer)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysPath := filepath.Join(t.TempDir(), "trusted.asc")
+	err = os.WriteFile(keysPath, keyRing.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestConfig(t, "trusted-gpg-keys "+keysPath+"\n")
+}
+
+func syncTestRemote(t *testing.T, remote *testRemote, path string, ref wikiRef) (string, error) {
+	return goGitSyncer{depth: 1}.Sync(context.Background(), remote.url, path, ref, nil)
+}
+
+func assertFileContents(t *testing.T, path string, want string) {
+	t.Helper()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != want {
+		t.Errorf("%s = %q, want %q", filepath.Base(path), contents, want)
+	}
+}
+
+func TestRepoSyncClone(t *testing.T) {
+	remote := newTestRemote(t)
+	first := remote.push("main", "cmd.md", "first")
+	path := filepath.Join(t.TempDir(), "clone")
+
+	// Without a branch the default branch of the remote is followed
+	revision, err := syncTestRemote(t, remote, path, wikiRef{})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if revision != first.String() {
+		t.Errorf("revision = %s, want %s", revision, first)
+	}
+	assertFileContents(t, filepath.Join(path, "cmd.md"), "first")
+}
+
+func TestRepoSyncFastForward(t *testing.T) {
+	remote := newTestRemote(t)
+	remote.push("main", "cmd.md", "first")
+	path := filepath.Join(t.TempDir(), "clone")
+	_, err := syncTestRemote(t, remote, path, wikiRef{Branch: "main"})
+	if err != nil {
+		t.Fatalf("first Sync() error = %v", err)
+	}
+
+	second := remote.push("main", "cmd.md", "second")
+	revision, err := syncTestRemote(t, remote, path, wikiRef{Branch: "main"})
+	if err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if revision != second.String() {
+		t.Errorf("revision = %s, want %s", revision, second)
+	}
+	assertFileContents(t, filepath.Join(path, "cmd.md"), "second")
+
+	// Syncing again without changes keeps the revision
+	revision, err = syncTestRemote(t, remote, path, wikiRef{Branch: "main"})
+	if err != nil || revision != second.String() {
+		t.Errorf("third Sync() = %s, %v, want %s", revision, err, second)
+	}
+}
+
+func TestRepoSyncDiverged(t *testing.T) {
+	remote := newTestRemote(t)
+	remote.push("main", "cmd.md", "first")
+	path := filepath.Join(t.TempDir(), "clone")
+	_, err := syncTestRemote(t, remote, path, wikiRef{Branch: "main"})
+	if err != nil {
+		t.Fatalf("first Sync() error = %v", err)
+	}
+
+	// A commit in the clone that is not on the remote, for example a command that was promoted
+	clone, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := commitFile(t, clone, "main", "local.md", "local")
+	remote.push("main", "cmd.md", "second")
+
+	_, err = syncTestRemote(t, remote, path, wikiRef{Branch: "main"})
+	var syncErr *RepoSyncError
+	if !errors.As(err, &syncErr) || syncErr.Kind != repoSyncDiverged {
+		t.Fatalf("Sync() error = %v, want a diverged error", err)
+	}
+	// The local commit is kept
+	head, err := clone.Head()
+	if err != nil || head.Hash() != local {
+		t.Errorf("HEAD = %v (%v), want the local commit %s", head, err, local)
+	}
+}
+
+func TestRepoSyncMissingBranch(t *testing.T) {
+	remote := newTestRemote(t)
+	remote.push("main", "cmd.md", "first")
+	path := filepath.Join(t.TempDir(), "clone")
+
+	_, err := syncTestRemote(t, remote, path, wikiRef{Branch: "does-not-exist"})
+	var syncErr *RepoSyncError
+	if !errors.As(err, &syncErr) || syncErr.Kind != repoSyncNotFound {
+		t.Fatalf("Sync() error = %v, want a not found error", err)
+	}
+	// A failed first clone is removed so the next update starts over
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the failed clone was not removed: %v", err)
+	}
+}
+
+func TestRepoSyncPin(t *testing.T) {
+	tests := []struct {
+		name string
+		// pin returns the pin for the first commit
+		pin func(remote *testRemote, first plumbing.Hash) string
+	}{
+		{
+			name: "commit",
+			pin:  func(remote *testRemote, first plumbing.Hash) string { return first.String() },
+		},
+		{
+			name: "tag",
+			pin: func(remote *testRemote, first plumbing.Hash) string {
+				remote.tag("v1", first, "", nil)
+				return "v1"
+			},
+		},
+		{
+			name: "annotated tag",
+			pin: func(remote *testRemote, first plumbing.Hash) string {
+				remote.tag("v1", first, "Release v1", nil)
+				return "v1"
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote := newTestRemote(t)
+			first := remote.push("main", "cmd.md", "first")
+			pin := test.pin(remote, first)
+			remote.push("main", "cmd.md", "second")
+			path := filepath.Join(t.TempDir(), "clone")
+
+			revision, err := syncTestRemote(t, remote, path, wikiRef{Branch: "main", Pin: pin})
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if revision != first.String() {
+				t.Errorf("revision = %s, want %s", revision, first)
+			}
+			assertFileContents(t, filepath.Join(path, "cmd.md"), "first")
+
+			// The pin is kept while the branch moves on
+			remote.push("main", "cmd.md", "third")
+			revision, err = syncTestRemote(t, remote, path, wikiRef{Branch: "main", Pin: pin})
+			if err != nil || revision != first.String() {
+				t.Errorf("second Sync() = %s, %v, want %s", revision, err, first)
+			}
+		})
+	}
+}
+
+func TestRepoSyncMovedTag(t *testing.T) {
+	remote := newTestRemote(t)
+	first := remote.push("main", "cmd.md", "first")
+	remote.tag("v1", first, "", nil)
+	path := filepath.Join(t.TempDir(), "clone")
+	_, err := syncTestRemote(t, remote, path, wikiRef{Pin: "v1"})
+	if err != nil {
+		t.Fatalf("first Sync() error = %v", err)
+	}
+
+	second := remote.push("main", "cmd.md", "second")
+	remote.tag("v1", second, "", nil)
+	_, err = syncTestRemote(t, remote, path, wikiRef{Pin: "v1"})
+	var syncErr *RepoSyncError
+	if !errors.As(err, &syncErr) || syncErr.Kind != repoSyncUnverified {
+		t.Fatalf("Sync() error = %v, want an unverified error", err)
+	}
+	assertFileContents(t, filepath.Join(path, "cmd.md"), "first")
+}
+
+func TestRepoSyncSignatures(t *testing.T) {
+	trusted := newSignKey(t, "trusted")
+	untrusted := newSignKey(t, "untrusted")
+	tests := []struct {
+		name      string
+		commitKey *openpgp.Entity
+		// tagKey signs an annotated tag the wiki is pinned to if it is set
+		tagKey     *openpgp.Entity
+		isVerified bool
+	}{
+		{name: "commit signed by a trusted key", commitKey: trusted, isVerified: true},
+		{name: "unsigned commit", isVerified: false},
+		{name: "commit signed by an untrusted key", commitKey: untrusted, isVerified: false},
+		{name: "unsigned commit with a tag signed by a trusted key", tagKey: trusted, isVerified: true},
+		{name: "unsigned commit with a tag signed by an untrusted key", tagKey: untrusted, isVerified: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remote := newTestRemote(t)
+			trustGPGKeys(t, trusted)
+			remote.signKey = test.commitKey
+			commit := remote.push("main", "cmd.md", "signed")
+			ref := wikiRef{Branch: "main"}
+			if test.tagKey != nil {
+				remote.tag("v1", commit, "Release v1", test.tagKey)
+				ref = wikiRef{Pin: "v1"}
+			}
+			path := filepath.Join(t.TempDir(), "clone")
+
+			revision, err := syncTestRemote(t, remote, path, ref)
+			if test.isVerified {
+				if err != nil {
+					t.Fatalf("Sync() error = %v", err)
+				}
+				if revision != commit.String() {
+					t.Errorf("revision = %s, want %s", revision, commit)
+				}
+				assertFileContents(t, filepath.Join(path, "cmd.md"), "signed")
+				return
+			}
+			var syncErr *RepoSyncError
+			if !errors.As(err, &syncErr) || syncErr.Kind != repoSyncUnverified {
+				t.Fatalf("Sync() error = %v, want an unverified error", err)
+			}
+			// Nothing is checked out before the signatures are verified
+			if _, err := os.Stat(filepath.Join(path, "cmd.md")); !os.IsNotExist(err) {
+				t.Errorf("the unverified commit was checked out: %v", err)
+			}
+		})
+	}
+}
+
+func TestSyncSourceRefusesUnsignedSources(t *testing.T) {
+	t.Setenv("CWC_CONFIG_DIR", t.TempDir())
+	trustGPGKeys(t, newSignKey(t, "trusted"))
+	dir := t.TempDir()
+	markdownPath := filepath.Join(dir, "commands.md")
+	err := os.WriteFile(markdownPath, []byte("### List files\n```bash\nls\n```\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		repo string
+	}{
+		{name: "local directory", repo: dir},
+		{name: "markdown file", repo: markdownPath},
+		{name: "archive", repo: "https://example.com/wiki.tar.gz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := syncSource(context.Background(), test.repo, filepath.Join(t.TempDir(), "repo"), wikiRef{})
+			if err == nil || !strings.Contains(err.Error(), "can not be signed") {
+				t.Fatalf("syncSource(%s) error = %v, want an error for a source that can not be signed", test.repo, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+// This file contains the verification of the signatures of the wiki before it is checked out. Updates are only
+// verified when trusted keys are configured:
+//
+//	trusted-gpg-keys     a file with the armored public GPG keys that may sign the wiki
+//	trusted-ssh-signers  an allowed signers file (see "ALLOWED SIGNERS" in ssh-keygen(1)) with the SSH keys
+//
+// The commit that is checked out has to be signed by one of the keys, like "git merge --verify-signatures".
+// When the wiki is pinned to an annotated tag a signature on the tag is accepted as well.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// signedObject is a commit or tag of the repository
+type signedObject struct {
+	kind      string
+	hash      plumbing.Hash
+	signature string
+	// payload returns the object without its signature, which is what was signed
+	payload func() ([]byte, error)
+	// verifyGPG checks the signature against the armored key ring
+	verifyGPG func(armoredKeyRing string) error
+}
+
+// isSignatureVerificationEnabled returns true if trusted keys are configured
+func isSignatureVerificationEnabled() bool {
+	return GetValueNoError("trusted-gpg-keys", "") != "" || GetValueNoError("trusted-ssh-signers", "") != ""
+}
+
+// verifyRevision checks that the commit, or the annotated tag pointing to it, is signed by a trusted key
+func verifyRevision(r *git.Repository, commitHash plumbing.Hash, tagHash plumbing.Hash) error {
+	if !isSignatureVerificationEnabled() {
+		return nil
+	}
+	commit, err := r.CommitObject(commitHash)
+	if err != nil {
+		return err
+	}
+	commitErr := verifySignature(signedObject{
+		kind:      "commit",
+		hash:      commit.Hash,
+		signature: commit.PGPSignature,
+		payload: func() ([]byte, error) {
+			encoded := &plumbing.MemoryObject{}
+			err := commit.EncodeWithoutSignature(encoded)
+			if err != nil {
+				return nil, err
+			}
+			return readEncodedObject(encoded)
+		},
+		verifyGPG: func(armoredKeyRing string) error {
+			_, err := commit.Verify(armoredKeyRing)
+			return err
+		},
+	})
+	if commitErr == nil || tagHash.IsZero() {
+		return commitErr
+	}
+
+	tag, err := r.TagObject(tagHash)
+	if err != nil {
+		return err
+	}
+	tagErr := verifySignature(signedObject{
+		kind:      "tag",
+		hash:      tag.Hash,
+		signature: tag.PGPSignature,
+		payload: func() ([]byte, error) {
+			encoded := &plumbing.MemoryObject{}
+			err := tag.EncodeWithoutSignature(encoded)
+			if err != nil {
+				return nil, err
+			}
+			return readEncodedObject(encoded)
+		},
+		verifyGPG: func(armoredKeyRing string) error {
+			_, err := tag.Verify(armoredKeyRing)
+			return err
+		},
+	})
+	if tagErr != nil {
+		return fmt.Errorf("%v and %v", commitErr, tagErr)
+	}
+	return nil
+}
+
+func readEncodedObject(o plumbing.EncodedObject) ([]byte, error) {
+	reader, err := o.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// verifySignature checks the GPG or SSH signature of the object with the configured keys
+func verifySignature(object signedObject) error {
+	shortHash := object.hash.String()[:7]
+	if object.signature == "" {
+		return fmt.Errorf("the %s %s is not signed", object.kind, shortHash)
+	}
+
+	if strings.Contains(object.signature, "-----BEGIN SSH SIGNATURE-----") {
+		allowedSigners := GetValueNoError("trusted-ssh-signers", "")
+		if allowedSigners == "" {
+			return fmt.Errorf("the %s %s is signed with an SSH key but trusted-ssh-signers is not set", object.kind, shortHash)
+		}
+		payload, err := object.payload()
+		if err != nil {
+			return err
+		}
+		err = verifySSHSignature(allowedSigners, object.signature, payload)
+		if err != nil {
+			return fmt.Errorf("the signature of the %s %s is not trusted: %w", object.kind, shortHash, err)
+		}
+		return nil
+	}
+
+	keysPath := GetValueNoError("trusted-gpg-keys", "")
+	if keysPath == "" {
+		return fmt.Errorf("the %s %s is signed with a GPG key but trusted-gpg-keys is not set", object.kind, shortHash)
+	}
+	keyRing, err := os.ReadFile(keysPath)
+	if err != nil {
+		return fmt.Errorf("failed to read trusted-gpg-keys: %w", err)
+	}
+	err = object.verifyGPG(string(keyRing))
+	if err != nil {
+		return fmt.Errorf("the signature of the %s %s is not trusted: %w", object.kind, shortHash, err)
+	}
+	return nil
+}
+
+// verifySSHSignature verifies the signature with ssh-keygen like git does, the principal is looked up in the
+// allowed signers file first
+func verifySSHSignature(allowedSigners string, signature string, payload []byte) error {
+	signatureFile, err := os.CreateTemp("", "cwc-signature-*.sig")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(signatureFile.Name())
+	_, err = signatureFile.WriteString(signature)
+	closeErr := signatureFile.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	output, err := exec.Command("ssh-keygen", "-Y", "find-principals", "-f", allowedSigners, "-s", signatureFile.Name()).Output()
+	if err != nil {
+		return fmt.Errorf("the key is not in %s", allowedSigners)
+	}
+	for _, principal := range strings.Fields(string(output)) {
+		verify := exec.Command("ssh-keygen", "-Y", "verify", "-f", allowedSigners, "-I", principal, "-n", "git", "-s", signatureFile.Name())
+		verify.Stdin = bytes.NewReader(payload)
+		if verify.Run() == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("the signature does not match the signed content")
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/log"
 
@@ -34,9 +35,15 @@ type listKeyMap struct {
 	togglePagination key.Binding
 	toggleHelpMenu   key.Binding
 	insertItem       key.Binding
+	reloadIndex      key.Binding
 }
 
 func search(searchterm string) {
+	showCommands(filterCommands(loadIndex(), searchterm), searchterm)
+}
+
+// loadIndex reads the index and creates it with the default settings if it does not exist yet
+func loadIndex() []Command {
 	checkIfUpdateNeeded()
 	commands, err := readIndex()
 	if err != nil {
@@ -45,24 +52,52 @@ func search(searchterm string) {
 			default_repo, err := GetRepo()
 			if err != nil {
 				log.Fatal("some error occured whilst getting the repo", "error", err)
-				return
 			}
-			err = updateIndex(default_repo, getDefaultBranch())
+			err = updateIndex(default_repo, getIndexRef(), getContentRoot())
 			if err != nil {
 				log.Fatal("some error occured whilst updating the index", "error", err)
-				return
 			}
 			commands, err = readIndex()
 			if err != nil {
 				log.Fatal("some error occured whilst reading the index", "error", err)
-				return
 			}
 		} else {
 			log.Fatal("some error occured whilst reading the index", "error", err)
-			return
 		}
 	}
+	return commands
+}
 
+// showCommands shows the command if there is only one, otherwise the user picks one of them from a list
+func showCommands(filteredCommands []Command, searchterm string) {
+	if len(filteredCommands) == 0 {
+		log.Info("No commands found", "searchterm", searchterm)
+		return
+	}
+
+	if len(filteredCommands) == 1 {
+		showCommmand(filteredCommands[0])
+		return
+	}
+
+	if _, err := tea.NewProgram(newSearchModel(filteredCommands, searchterm)).Run(); err != nil {
+		log.Fatal("error during program execution", "error", err)
+	}
+
+	if selectedCommand != nil {
+		showCommmand(*selectedCommand)
+	}
+
+}
+
+type scoredCommand struct {
+	command Command
+	score   float32
+}
+
+// scoreCommands scores how well every command matches the searchterm and returns the commands that match,
+// the best match first
+func scoreCommands(commands []Command, searchterm string) []scoredCommand {
 	searchtermWords := strings.Split(searchterm, " ")
 	// Remove empty entries
 	for i := 0; i < len(searchtermWords); i++ {
@@ -72,8 +107,7 @@ func search(searchterm string) {
 		}
 	}
 
-	// Create a map of all commands and their scores
-	commandScores := make(map[string]float32)
+	var scoredCommands []scoredCommand
 
 	// Search if the command title or content contains one or more words from the searchterm
 	// Use case-insensitive search
@@ -110,53 +144,14 @@ func search(searchterm string) {
 		}
 
 		if score > 0 {
-			// Add the command to commandscores
-			commandScores[cmd.CmdTitle] = score
+			scoredCommands = append(scoredCommands, scoredCommand{command: cmd, score: score})
 		}
 	}
 
-	keys := make([]string, 0, len(commandScores))
-
-	for key := range commandScores {
-		keys = append(keys, key)
-	}
-
-	sort.SliceStable(keys, func(i, j int) bool {
-		return commandScores[keys[i]] > commandScores[keys[j]]
+	sort.SliceStable(scoredCommands, func(i, j int) bool {
+		return scoredCommands[i].score > scoredCommands[j].score
 	})
-
-	var filteredCommands []Command
-	if searchterm == "" {
-		filteredCommands = commands
-	} else {
-		for _, k := range keys {
-			// Find the command
-			for _, cmd := range commands {
-				if cmd.CmdTitle == k {
-					filteredCommands = append(filteredCommands, cmd)
-				}
-			}
-		}
-	}
-
-	if len(filteredCommands) == 0 {
-		log.Info("No commands found", "searchterm", searchterm)
-		return
-	}
-
-	if len(filteredCommands) == 1 {
-		showCommmand(filteredCommands[0])
-		return
-	}
-
-	if _, err := tea.NewProgram(newSearchModel(filteredCommands)).Run(); err != nil {
-		log.Fatal("error during program execution", "error", err)
-	}
-
-	if selectedCommand != nil {
-		showCommmand(*selectedCommand)
-	}
-
+	return scoredCommands
 }
 
 func newListKeyMap() *listKeyMap {
@@ -181,6 +176,11 @@ func newListKeyMap() *listKeyMap {
 			key.WithKeys("H"),
 			key.WithHelp("H", "toggle help"),
 		),
+		reloadIndex: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "reload the updated index"),
+			key.WithDisabled(),
+		),
 	}
 }
 
@@ -188,9 +188,11 @@ type searchModel struct {
 	list         list.Model
 	keys         *listKeyMap
 	delegateKeys *searchDelegateKeyMap
+	searchterm   string
+	indexModTime time.Time
 }
 
-func newSearchModel(cmds []Command) searchModel {
+func newSearchModel(cmds []Command, searchterm string) searchModel {
 	var (
 		delegateKeys = newSearchDelegateKeyMap()
 		listKeys     = newListKeyMap()
@@ -216,6 +218,7 @@ func newSearchModel(cmds []Command) searchModel {
 			listKeys.toggleStatusBar,
 			listKeys.togglePagination,
 			listKeys.toggleHelpMenu,
+			listKeys.reloadIndex,
 		}
 	}
 
@@ -223,11 +226,25 @@ func newSearchModel(cmds []Command) searchModel {
 		list:         commandsList,
 		keys:         listKeys,
 		delegateKeys: delegateKeys,
+		searchterm:   searchterm,
+		indexModTime: getIndexModTime(),
 	}
 }
 
 func (m searchModel) Init() tea.Cmd {
-	return tea.EnterAltScreen
+	return tea.Batch(tea.EnterAltScreen, watchIndexUpdate(m.indexModTime))
+}
+
+// filterCommands returns the commands matching the searchterm, or all commands without a searchterm
+func filterCommands(commands []Command, searchterm string) []Command {
+	if searchterm == "" {
+		return commands
+	}
+	var filteredCommands []Command
+	for _, scored := range scoreCommands(commands, searchterm) {
+		filteredCommands = append(filteredCommands, scored.command)
+	}
+	return filteredCommands
 }
 
 func (m searchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -238,6 +255,13 @@ func (m searchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		h, v := appStyle.GetFrameSize()
 		m.list.SetSize(msg.Width-h, msg.Height-v)
 
+	case indexCheckMsg:
+		if !msg.isUpdated {
+			return m, watchIndexUpdate(m.indexModTime)
+		}
+		m.keys.reloadIndex.SetEnabled(true)
+		return m, m.list.NewStatusMessage(statusMessageStyle("Index updated, press r to reload"))
+
 	case tea.KeyMsg:
 		// Don't match any of the keys below if we're actively filtering.
 		if m.list.FilterState() == list.Filtering {
@@ -267,6 +291,19 @@ func (m searchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.toggleHelpMenu):
 			m.list.SetShowHelp(!m.list.ShowHelp())
 			return m, nil
+
+		case key.Matches(msg, m.keys.reloadIndex):
+			commands, err := readIndex()
+			if err != nil {
+				return m, m.list.NewStatusMessage(statusMessageStyle("Failed to reload the index: " + err.Error()))
+			}
+			var items []list.Item
+			for _, cmd := range filterCommands(commands, m.searchterm) {
+				items = append(items, cmd)
+			}
+			m.keys.reloadIndex.SetEnabled(false)
+			m.indexModTime = getIndexModTime()
+			return m, tea.Batch(m.list.SetItems(items), m.list.NewStatusMessage(statusMessageStyle("Reloaded the index")), watchIndexUpdate(m.indexModTime))
 		}
 	}
 
@@ -0,0 +1,111 @@
+package main
+
+// This file contains everything for resolving secret variables
+// A secret variable is a variable with type=secret (or type=password) in its metadata, for example:
+// [token]: <> (type=secret source="pass work/api-token" desc="The API token")
+//
+// Secrets are never written into the script that is executed, instead the script references
+// an environment variable which is only set for the child process.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+)
+
+// isSecretVariable returns true if the variable metadata marks the variable as a secret
+func isSecretVariable(variableMetadata map[string]string) bool {
+	variableType := variableMetadata["type"]
+	return variableType == "secret" || variableType == "password"
+}
+
+// secretEnvName returns the name of the environment variable that holds the value of a secret variable
+func secretEnvName(variable string) string {
+	var name strings.Builder
+	name.WriteString("CWC_SECRET_")
+	for _, r := range variable {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			name.WriteRune(unicode.ToUpper(r))
+		} else {
+			name.WriteRune('_')
+		}
+	}
+	return name.String()
+}
+
+// secretEnv returns the environment variables with the values of the secret variables of the command, an error is
+// returned if two secrets would be stored in the same environment variable, like api-key and api_key
+func secretEnv(cmd Command, variables map[string]string) ([]string, error) {
+	var env []string
+	secretOfEnv := make(map[string]string)
+	for variable, value := range variables {
+		if !isSecretVariable(cmd.Metadata[variable]) {
+			continue
+		}
+		name := secretEnvName(variable)
+		if other, ok := secretOfEnv[name]; ok {
+			return nil, fmt.Errorf("the secrets %s and %s would both be passed as %s, rename one of them", other, variable, name)
+		}
+		secretOfEnv[name] = variable
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
+
+// secretReferenceIn returns the reference to the environment variable of a secret variable whose placeholder is
+// inside of the quote, quote is 0 outside of quotes. An error is returned if the reference would not be read from
+// the environment there, for example inside of a python string.
+func secretReferenceIn(interp interpreter, variable string, quote byte, isComment bool) (string, error) {
+	reference := fmt.Sprintf(interp.SecretReference, secretEnvName(variable))
+	switch {
+	case quote == 0 || isComment:
+		return reference, nil
+	case quote == '"' && interp.SecretQuoting != quotingExpression:
+		return reference, nil
+	case quote == '\'' && interp.SecretQuoting == quotingShell:
+		// Close the single quotes around the reference, the shell joins the quoted parts into one word
+		return `'"` + reference + `"'`, nil
+	}
+	return reference, fmt.Errorf("the secret %s can not be used inside of %c quotes as it would not be read from the environment, move it out of the quotes", variable, quote)
+}
+
+// resolveSecret reads a secret from the given source
+// The source looks like one of:
+//   - env <NAME>                    reads the environment variable NAME
+//   - pass <entry>                  reads the first line of the pass entry
+//   - keyring <attribute> <value>   looks up the secret in the libsecret keyring using secret-tool
+func resolveSecret(source string) (string, error) {
+	fields := strings.Fields(source)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("invalid secret source %q", source)
+	}
+
+	switch fields[0] {
+	case "env":
+		value, ok := os.LookupEnv(fields[1])
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", fields[1])
+		}
+		return value, nil
+	case "pass":
+		output, err := exec.Command("pass", "show", fields[1]).Output()
+		if err != nil {
+			return "", fmt.Errorf("pass show %s failed: %w", fields[1], err)
+		}
+		// pass stores the password on the first line, the rest is metadata
+		password, _, _ := strings.Cut(string(output), "\n")
+		return password, nil
+	case "keyring":
+		if len(fields)%2 != 1 {
+			return "", fmt.Errorf("keyring source needs attribute value pairs, got %q", source)
+		}
+		output, err := exec.Command("secret-tool", append([]string{"lookup"}, fields[1:]...)...).Output()
+		if err != nil {
+			return "", fmt.Errorf("secret-tool lookup failed: %w", err)
+		}
+		return strings.TrimSuffix(string(output), "\n"), nil
+	}
+	return "", fmt.Errorf("unknown secret source type %q", fields[0])
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSubstituteVariables(t *testing.T) {
+	metadata := map[string]map[string]string{"token": {"type": "secret"}}
+	variables := map[string]string{"token": "s3cr3t", "host": "example.com"}
+	tests := []struct {
+		name     string
+		language string
+		content  string
+		expected string
+		isError  bool
+	}{
+		{
+			name:     "bash without quotes",
+			language: "bash",
+			content:  "curl -u {token} https://{host}",
+			expected: "curl -u ${CWC_SECRET_TOKEN} https://example.com\n",
+		},
+		{
+			name:     "bash in double quotes",
+			language: "bash",
+			content:  `curl -H "Authorization: Bearer {token}" {host}`,
+			expected: `curl -H "Authorization: Bearer ${CWC_SECRET_TOKEN}" example.com` + "\n",
+		},
+		{
+			name:     "bash in single quotes",
+			language: "bash",
+			content:  "curl -H 'Authorization: {token}' '{host}'",
+			expected: `curl -H 'Authorization: '"${CWC_SECRET_TOKEN}"'' 'example.com'` + "\n",
+		},
+		{
+			name:     "bash with an escaped quote",
+			language: "bash",
+			content:  `echo \' <token> '\'`,
+			expected: `echo \' ${CWC_SECRET_TOKEN} '\'` + "\n",
+		},
+		{
+			name:     "bash quote in a comment",
+			language: "bash",
+			content:  "# don't print it\necho <token>",
+			expected: "# don't print it\necho ${CWC_SECRET_TOKEN}\n",
+		},
+		{
+			name:     "powershell in double quotes",
+			language: "powershell",
+			content:  `Invoke-RestMethod -Headers @{Authorization="Bearer {token}"}`,
+			expected: `Invoke-RestMethod -Headers @{Authorization="Bearer $env:CWC_SECRET_TOKEN"}` + "\n",
+		},
+		{
+			name:     "powershell in single quotes",
+			language: "powershell",
+			content:  `Write-Output 'token: {token}'`,
+			isError:  true,
+		},
+		{
+			name:     "python without quotes",
+			language: "python",
+			content:  `headers = {"Authorization": {token}}`,
+			expected: `headers = {"Authorization": __import__("os").environ["CWC_SECRET_TOKEN"]}` + "\n",
+		},
+		{
+			name:     "python in quotes",
+			language: "python",
+			content:  `print("token: {token}")`,
+			isError:  true,
+		},
+		{
+			name:     "javascript in a template literal",
+			language: "javascript",
+			content:  "console.log(`token: {token}`)",
+			isError:  true,
+		},
+		{
+			name:     "language without an interpreter",
+			language: "yaml",
+			content:  "token: '{token}'\nhost: {host}",
+			expected: "token: '{token}'\nhost: example.com\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := Command{Content: test.content, Language: test.language, Metadata: metadata}
+			content, err := substituteVariables(cmd, variables, defaultInterpreters[test.language])
+			if test.isError {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if content != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, content)
+			}
+		})
+	}
+}
+
+func TestSecretEnv(t *testing.T) {
+	cmd := Command{Metadata: map[string]map[string]string{
+		"api-key":  {"type": "secret"},
+		"api_key":  {"type": "password"},
+		"password": {"type": "secret"},
+	}}
+
+	env, err := secretEnv(cmd, map[string]string{"api-key": "a", "password": "b", "host": "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(env)
+	expected := []string{"CWC_SECRET_API_KEY=a", "CWC_SECRET_PASSWORD=b"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %v, got %v", expected, env)
+	}
+
+	_, err = secretEnv(cmd, map[string]string{"api-key": "a", "api_key": "b"})
+	if err == nil {
+		t.Error("expected an error for secrets with the same environment variable")
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+// This file contains the sources the index can be built from, the "repo" in the config or --repo can be:
+//   - a git repository (the default)
+//   - a local directory, for example a checked out docs folder, directories ending in ".git" are repositories
+//   - a single markdown file, local or an http(s) url
+//   - an http(s) url of a .tar.gz, .tgz, .tar or .zip archive
+//
+// For directories, repositories and archives only the markdown files under the content root are indexed.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type sourceKind int
+
+const (
+	gitSource sourceKind = iota
+	localDirSource
+	markdownFileSource
+	archiveSource
+)
+
+func (k sourceKind) String() string {
+	switch k {
+	case localDirSource:
+		return "local directory"
+	case markdownFileSource:
+		return "markdown file"
+	case archiveSource:
+		return "archive"
+	}
+	return "git repository"
+}
+
+const wikiContentRoot = "src/content/docs/commands"
+
+func isUrl(repo string) bool {
+	return strings.HasPrefix(repo, "http://") || strings.HasPrefix(repo, "https://")
+}
+
+func isArchive(repo string) bool {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(repo), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceKindOf returns the kind of source repo points to
+func sourceKindOf(repo string) sourceKind {
+	isMarkdown := strings.EqualFold(path.Ext(repo), ".md")
+	if isUrl(repo) {
+		switch {
+		case isMarkdown:
+			return markdownFileSource
+		case isArchive(repo):
+			return archiveSource
+		}
+		return gitSource
+	}
+	localPath := strings.TrimPrefix(repo, "file://")
+	if info, err := os.Stat(localPath); err == nil {
+		// Local repositories like /srv/git/wiki.git are cloned like remote ones
+		if info.IsDir() && !strings.HasSuffix(strings.TrimSuffix(localPath, "/"), ".git") {
+			return localDirSource
+		}
+		if isMarkdown {
+			return markdownFileSource
+		}
+	}
+	return gitSource
+}
+
+// getRepoNameFor returns the name of the directories the source is stored in, the last two parts of its path
+func getRepoNameFor(repo string) (string, error) {
+	if kind := sourceKindOf(repo); !isUrl(repo) && (kind == localDirSource || kind == markdownFileSource) {
+		absolutePath, err := filepath.Abs(strings.TrimPrefix(repo, "file://"))
+		if err != nil {
+			return "", err
+		}
+		repo = filepath.ToSlash(absolutePath)
+	}
+	split := strings.Split(strings.TrimSuffix(repo, "/"), "/")
+	if len(split) < 2 {
+		return "", fmt.Errorf("repo name not found in repo url")
+	}
+	return split[len(split)-2] + "/" + split[len(split)-1], nil
+}
+
+// syncSource makes the files of the source available in repoPath, it returns the path of the directory or
+// markdown file to index and the revision of the source if it has one
+func syncSource(ctx context.Context, repo string, repoPath string, ref wikiRef) (string, string, error) {
+	kind := sourceKindOf(repo)
+	// Only commits and tags carry signatures, other sources would bypass the verification
+	if kind != gitSource && isSignatureVerificationEnabled() {
+		return "", "", fmt.Errorf("the source is a %s which can not be signed, remove trusted-gpg-keys and trusted-ssh-signers from the config to use it", kind)
+	}
+	switch kind {
+	case localDirSource, markdownFileSource:
+		if !isUrl(repo) {
+			sourcePath, err := filepath.Abs(strings.TrimPrefix(repo, "file://"))
+			return sourcePath, "", err
+		}
+		err := os.MkdirAll(repoPath, 0744)
+		if err != nil {
+			return "", "", err
+		}
+		markdownPath := filepath.Join(repoPath, path.Base(repo))
+		err = downloadFile(ctx, repo, markdownPath)
+		return markdownPath, "", err
+	case archiveSource:
+		root, err := syncArchive(ctx, repo, repoPath)
+		return root, "", err
+	}
+	revision, err := newRepoSyncer().Sync(ctx, repo, repoPath, ref, os.Stderr)
+	return repoPath, revision, err
+}
+
+func downloadFile(ctx context.Context, url string, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s failed: %s", url, resp.Status)
+	}
+
+	// Download next to the target first so a failed download keeps the previous version
+	tmpPath := target + ".download"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, resp.Body)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, target)
+}
+
+// syncArchive downloads and extracts the archive into repoPath, it returns the directory with the contents of
+// the archive, archives with a single top level directory (like the ones github creates) return that directory
+func syncArchive(ctx context.Context, url string, repoPath string) (string, error) {
+	err := os.MkdirAll(filepath.Dir(repoPath), 0744)
+	if err != nil {
+		return "", err
+	}
+	archivePath := repoPath + ".archive"
+	err = downloadFile(ctx, url, archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archivePath)
+
+	// Extract next to the old contents and swap them when it succeeded
+	extractPath := repoPath + ".extract"
+	os.RemoveAll(extractPath)
+	if strings.HasSuffix(strings.ToLower(url), ".zip") {
+		err = extractZip(archivePath, extractPath)
+	} else {
+		err = extractTar(archivePath, extractPath, !strings.HasSuffix(strings.ToLower(url), ".tar"))
+	}
+	if err != nil {
+		os.RemoveAll(extractPath)
+		return "", fmt.Errorf("failed to extract %s: %w", url, err)
+	}
+	err = os.RemoveAll(repoPath)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(extractPath, repoPath)
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(repoPath, entries[0].Name()), nil
+	}
+	return repoPath, nil
+}
+
+// extractPath returns where name should be extracted to, it fails for names that would end up outside of dir
+func extractPath(dir string, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("the archive contains the invalid path %s", name)
+	}
+	return target, nil
+}
+
+func extractTar(archivePath string, dir string, isGzip bool) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if isGzip {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := extractPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		// Links are skipped, the index only needs the markdown files
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0744)
+		case tar.TypeReg:
+			err = writeExtractedFile(target, tarReader)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(archivePath string, dir string) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+	for _, file := range zipReader.File {
+		target, err := extractPath(dir, file.Name)
+		if err != nil {
+			return err
+		}
+		if file.FileInfo().IsDir() {
+			err = os.MkdirAll(target, 0744)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		contents, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeExtractedFile(target, contents)
+		contents.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeExtractedFile(target string, contents io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(target), 0744)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, contents)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is a file of a test archive, entries ending in "/" are directories
+type archiveEntry struct {
+	name     string
+	contents string
+}
+
+func newTarArchive(t *testing.T, entries []archiveEntry, isGzip bool) []byte {
+	var archive bytes.Buffer
+	var gzipWriter *gzip.Writer
+	tarWriter := tar.NewWriter(&archive)
+	if isGzip {
+		gzipWriter = gzip.NewWriter(&archive)
+		tarWriter = tar.NewWriter(gzipWriter)
+	}
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.contents)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(entry.name, "/") {
+			header = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		err := tarWriter.WriteHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tarWriter.Write([]byte(entry.contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := tarWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gzipWriter != nil {
+		err = gzipWriter.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return archive.Bytes()
+}
+
+func newZipArchive(t *testing.T, entries []archiveEntry) []byte {
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	for _, entry := range entries {
+		writer, err := zipWriter.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = writer.Write([]byte(entry.contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := zipWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+func TestExtractPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{name: "file", entry: "wiki/commands/disk.md", want: filepath.Join(dir, "wiki", "commands", "disk.md")},
+		{name: "directory", entry: "wiki/", want: filepath.Join(dir, "wiki")},
+		{name: "dot", entry: "./wiki/disk.md", want: filepath.Join(dir, "wiki", "disk.md")},
+		{name: "parent", entry: "../evil.md", wantErr: true},
+		{name: "parent in the middle", entry: "wiki/../../evil.md", wantErr: true},
+		{name: "parent that stays inside", entry: "wiki/../disk.md", want: filepath.Join(dir, "disk.md")},
+		// Absolute names are extracted below dir like tar does after stripping the leading "/"
+		{name: "absolute", entry: "/etc/evil.md", want: filepath.Join(dir, "etc", "evil.md")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := extractPath(dir, test.entry)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("extractPath(%q) = %s, want an error", test.entry, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractPath(%q) error = %v", test.entry, err)
+			}
+			if got != test.want {
+				t.Errorf("extractPath(%q) = %s, want %s", test.entry, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSyncArchive(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "wiki-main/"},
+		{name: "wiki-main/src/content/docs/commands/disk.md", contents: "### Disk usage\n```bash\ndu -sh\n```\n"},
+		{name: "wiki-main/README.md", contents: "# Wiki\n"},
+	}
+	tests := []struct {
+		name    string
+		path    string
+		archive []byte
+	}{
+		{name: "tar.gz", path: "/wiki.tar.gz", archive: newTarArchive(t, entries, true)},
+		{name: "tgz", path: "/wiki.tgz", archive: newTarArchive(t, entries, true)},
+		{name: "tar", path: "/wiki.tar", archive: newTarArchive(t, entries, false)},
+		{name: "zip", path: "/wiki.zip", archive: newZipArchive(t, entries[1:])},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(test.archive)
+			}))
+			defer server.Close()
+			repoPath := filepath.Join(t.TempDir(), "repos", "wiki")
+
+			root, err := syncArchive(context.Background(), server.URL+test.path, repoPath)
+			if err != nil {
+				t.Fatalf("syncArchive() error = %v", err)
+			}
+			// The single top level directory of the archive is the root of the wiki
+			if root != filepath.Join(repoPath, "wiki-main") {
+				t.Errorf("root = %s, want the top level directory of the archive", root)
+			}
+			assertFileContents(t, filepath.Join(root, "src", "content", "docs", "commands", "disk.md"), entries[1].contents)
+			assertFileContents(t, filepath.Join(root, "README.md"), entries[2].contents)
+			if _, err := os.Stat(repoPath + ".archive"); !os.IsNotExist(err) {
+				t.Errorf("the downloaded archive was not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSyncArchiveRefusesPathsOutsideOfTheArchive(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "wiki/disk.md", contents: "### Disk usage\n"},
+		{name: "../evil.md", contents: "evil"},
+	}
+	tests := []struct {
+		name    string
+		path    string
+		archive []byte
+	}{
+		{name: "tar.gz", path: "/wiki.tar.gz", archive: newTarArchive(t, entries, true)},
+		{name: "zip", path: "/wiki.zip", archive: newZipArchive(t, entries)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(test.archive)
+			}))
+			defer server.Close()
+			reposPath := filepath.Join(t.TempDir(), "repos")
+			repoPath := filepath.Join(reposPath, "wiki")
+
+			_, err := syncArchive(context.Background(), server.URL+test.path, repoPath)
+			if err == nil || !strings.Contains(err.Error(), "invalid path") {
+				t.Fatalf("syncArchive() error = %v, want an invalid path error", err)
+			}
+			if _, err := os.Stat(filepath.Join(reposPath, "evil.md")); !os.IsNotExist(err) {
+				t.Errorf("a file outside of the archive was written: %v", err)
+			}
+			// Nothing is left behind of the failed extraction
+			if _, err := os.Stat(repoPath + ".extract"); !os.IsNotExist(err) {
+				t.Errorf("the failed extraction was not removed: %v", err)
+			}
+		})
+	}
+}
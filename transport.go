@@ -0,0 +1,117 @@
+package main
+
+// This file contains the transports used to run a generated script, either on this machine or on a remote host over ssh
+
+import (
+	"bufio"
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// targetHost is the host commands are executed on, it is set with the --host flag or the "host" config key
+// An empty host (or "local") executes commands on this machine
+var targetHost string
+
+// commandTransport creates the process that runs a script
+type commandTransport interface {
+	// Name returns the name of the host the script runs on
+	Name() string
+	// Command returns the (not yet started) process that runs the script at scriptPath with the interpreter.
+	// env contains "KEY=value" pairs that have to be available to the script.
+	Command(interpreter []string, scriptPath string, env []string) (*exec.Cmd, error)
+}
+
+func newTransport(host string) commandTransport {
+	if host == "" || host == "local" {
+		return localTransport{}
+	}
+	return sshTransport{host: host}
+}
+
+type localTransport struct{}
+
+func (t localTransport) Name() string { return "local" }
+
+func (t localTransport) Command(interpreter []string, scriptPath string, env []string) (*exec.Cmd, error) {
+	cmd := exec.Command(interpreter[0], append(interpreter[1:], scriptPath)...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd, nil
+}
+
+// sshTransport ships the script to the host as part of the remote command, writes it to a private
+// temporary directory on the host and runs it there with a tty so interactive commands keep working.
+// Secrets are forwarded with SendEnv, so the sshd on the host has to accept CWC_SECRET_* (AcceptEnv).
+type sshTransport struct {
+	host string
+}
+
+func (t sshTransport) Name() string { return t.host }
+
+func (t sshTransport) Command(interpreter []string, scriptPath string, env []string) (*exec.Cmd, error) {
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var quotedInterpreter []string
+	for _, arg := range interpreter {
+		quotedInterpreter = append(quotedInterpreter, shellQuote(arg))
+	}
+	remoteScript := `"$d"/` + shellQuote(filepath.Base(scriptPath))
+	remoteCommand := `d=$(mktemp -d) && printf %s ` + shellQuote(base64.StdEncoding.EncodeToString(script)) +
+		` | base64 -d > ` + remoteScript + ` && ` + strings.Join(quotedInterpreter, " ") + ` ` + remoteScript +
+		`; rc=$?; rm -rf "$d"; exit $rc`
+	// ssh runs the command with the login shell of the user, which does not have to be a POSIX shell like fish
+	remoteCommand = "sh -c " + shellQuote(remoteCommand)
+
+	args := []string{"-t"}
+	if len(env) > 0 {
+		args = append(args, "-o", "SendEnv=CWC_SECRET_*")
+	}
+	args = append(args, t.host, remoteCommand)
+
+	cmd := exec.Command("ssh", args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd, nil
+}
+
+// shellQuote quotes the value so that a POSIX shell reads it as a single word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
+
+// readSshHosts returns the hosts from ~/.ssh/config, patterns like "*.example.com" are skipped
+func readSshHosts() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	file, err := os.Open(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var hosts []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "Host") {
+			continue
+		}
+		for _, host := range fields[1:] {
+			if strings.ContainsAny(host, "*?!") {
+				continue
+			}
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSshTransportCommand(t *testing.T) {
+	for _, tool := range []string{"bash", "base64", "mktemp"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is needed to run the remote command locally", tool)
+		}
+	}
+	scriptPath := filepath.Join(t.TempDir(), "it's a script.sh")
+	script := "echo \"it's $((1 + 1))\" '$HOME' \"$CWC_SECRET_TOKEN\" \\\\ \"$0\"\n"
+	err := os.WriteFile(scriptPath, []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		interpreter []string
+		env         []string
+		wantOutput  string
+	}{
+		{
+			name:        "quotes in the script and file name",
+			interpreter: []string{"sh"},
+			wantOutput:  "it's 2 $HOME  \\ ",
+		},
+		{
+			name:        "quotes in the interpreter",
+			interpreter: []string{"bash", "-c", `. "$0" && echo "it's sourced"`},
+			wantOutput:  "it's 2 $HOME  \\ ",
+		},
+		{
+			name:        "secrets are forwarded",
+			interpreter: []string{"sh"},
+			env:         []string{"CWC_SECRET_TOKEN=s3cr'et $HOME"},
+			wantOutput:  "it's 2 $HOME s3cr'et $HOME \\ ",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := sshTransport{host: "user@example.com"}.Command(test.interpreter, scriptPath, test.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantArgs := []string{"ssh", "-t"}
+			if len(test.env) > 0 {
+				wantArgs = append(wantArgs, "-o", "SendEnv=CWC_SECRET_*")
+			}
+			wantArgs = append(wantArgs, "user@example.com")
+			if args := cmd.Args[:len(cmd.Args)-1]; !reflect.DeepEqual(args, wantArgs) {
+				t.Errorf("ssh arguments = %q, want %q", args, wantArgs)
+			}
+			for _, variable := range test.env {
+				if !slices.Contains(cmd.Env, variable) {
+					t.Errorf("the environment of ssh does not contain %q", variable)
+				}
+			}
+
+			// Run the remote command like sshd does, with the login shell of the user and the forwarded variables
+			remote := exec.Command("bash", "-c", cmd.Args[len(cmd.Args)-1])
+			remote.Env = append(os.Environ(), test.env...)
+			output, err := remote.CombinedOutput()
+			if err != nil {
+				t.Fatalf("the remote command failed: %v\n%s", err, output)
+			}
+			// The script prints its path last, it is in the temporary directory that has to be removed afterwards
+			gotOutput, remoteScriptPath, _ := strings.Cut(string(output), "/")
+			remoteScriptPath = "/" + remoteScriptPath
+			if gotOutput != test.wantOutput || !strings.HasPrefix(filepath.Base(remoteScriptPath), "it's a script.sh\n") {
+				t.Errorf("output = %q, want %q and the script", output, test.wantOutput)
+			}
+			if _, err := os.Stat(filepath.Dir(remoteScriptPath)); !os.IsNotExist(err) {
+				t.Errorf("the temporary directory of the script was not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadSshHosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{name: "no config", want: nil},
+		{
+			name:   "hosts and aliases",
+			config: "Host web1 web2\n  HostName 10.0.0.1\n  User deploy\n\nhost db\n\tPort 2222\nHOST\tbackup.example.com\n",
+			want:   []string{"web1", "web2", "db", "backup.example.com"},
+		},
+		{
+			name:   "patterns are skipped",
+			config: "Host *\n  ServerAliveInterval 60\nHost *.internal bastion !jump web?\nMatch host gateway\n",
+			want:   []string{"bastion"},
+		},
+		{
+			name:   "comments and options that contain host",
+			config: "# Host commented\n  HostName example.com\n  HostKeyAlias other\nHost\n",
+			want:   nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			if test.config != "" {
+				err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(test.config), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := readSshHosts(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("readSshHosts() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
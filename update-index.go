@@ -1,19 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/charmbracelet/log"
 )
 
-func updateIndex(repo string, branch string) error {
+func updateIndex(repo string, ref wikiRef, contentRoot string) error {
 	// Define where to put the cloned repo, it should be in the config directory with the "repos/<reponame>" directory
 	// If the directory does not exist, create it
-	configPath, err := os.UserConfigDir()
+	configPath, err := getUserConfigDir()
 	if err != nil {
 		return err
 	}
@@ -22,47 +27,77 @@ func updateIndex(repo string, branch string) error {
 	if err != nil {
 		return err
 	}
+	// Concurrent updates would clone into the same directory
+	releaseLock, err := acquireUpdateLock()
+	if err != nil {
+		return err
+	}
+	defer releaseLock()
+
 	repo_path := filepath.Join(configPath, "commands-wiki", "repos", repo_name)
-	log.Infof("cloning repo %s into %s\n", repo, repo_path)
-	config, err := os.UserConfigDir()
+	log.Infof("syncing %s into %s\n", repo, repo_path)
+	config, err := getUserConfigDir()
 	if err != nil {
 		return err
 	}
 	if config == "" {
 		return fmt.Errorf("config is empty")
 	}
-	// If the directory exists, update the repo
-	if _, err := os.Stat(repo_path); err == nil {
-		log.Info("updating repo")
-		cmd := execCommand("git", []string{"-C", repo_path, "pull"})
-		if cmd.ProcessState.ExitCode() != 0 {
-			return fmt.Errorf("git pull failed")
-		}
-	} else {
-		cmd := execCommand("git", []string{"clone", "-b", branch, repo, repo_path})
-		if cmd.ProcessState.ExitCode() != 0 {
-			return fmt.Errorf("git clone failed")
-		}
+	sourcePath, revision, err := syncSource(context.Background(), repo, repo_path, ref)
+	if err != nil {
+		return err
+	}
+	if revision != "" {
+		log.Info("synced repo", "ref", ref, "revision", revision[:7])
 	}
 
-	// Checkout the branch we have selected
-	cmd := execCommand("git", []string{"-C", repo_path, "checkout", branch})
-	if cmd.ProcessState.ExitCode() != 0 {
-		return fmt.Errorf("git checkout failed")
+	indexFilePath, err := getIndexFilePath()
+	if err != nil {
+		return err
 	}
+	// Older versions of cwc wrote the markdown of every command to this directory
+	legacyMarkdownRoot := filepath.Join(filepath.Dir(indexFilePath), "cmds")
 
-	// Find all .md files recursively in the "src/content/docs/commands/" of the repo
+	// Nothing changed in the source since the last update, the generated commands are kept in sync by "cwc ai".
+	// Indexes of older versions of cwc are always rebuilt.
+	var indexedContentRoot string
+	readIndexMetadata("contentRoot", &indexedContentRoot)
+	indexed, indexErr := readIndexEnvelope()
+	isIndexCurrent := indexErr == nil && indexed.migratedFrom == 0
+	if _, err := os.Stat(indexFilePath); err == nil && isIndexCurrent && revision != "" && revision == indexed.Meta.Commit && contentRoot == indexedContentRoot {
+		log.Info("the index is up to date", "revision", revision[:7])
+		setIndexUpdateTimeToNow()
+		return nil
+	}
+
+	// Find all .md files recursively in the content root of the source, "src/content/docs/commands/" by default
 	var commandsFiles []string
-	err = filepath.Walk(filepath.Join(repo_path, "src", "content", "docs", "commands"), func(path string, info os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".md" {
-			commandsFiles = append(commandsFiles, path)
+	contentPath := filepath.Join(sourcePath, filepath.FromSlash(contentRoot))
+	if info, err := os.Stat(sourcePath); err == nil && !info.IsDir() {
+		commandsFiles = append(commandsFiles, sourcePath)
+		contentPath = filepath.Dir(sourcePath)
+	} else {
+		if _, err := os.Stat(contentPath); err != nil {
+			return fmt.Errorf("the content root %s does not exist in %s, set it with --content-root", contentRoot, repo)
 		}
-		return nil
-	})
+		err = filepath.Walk(contentPath, func(path string, info os.FileInfo, err error) error {
+			if filepath.Ext(path) == ".md" {
+				commandsFiles = append(commandsFiles, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
 
+	// Hold the index from reading the generated commands until the new index is written, a command saved by
+	// "cwc ai" in between would be dropped otherwise
+	releaseIndexLock, err := lockIndex(indexFilePath)
 	if err != nil {
 		return err
 	}
+	defer releaseIndexLock()
 
 	ai_commands_path := filepath.Join(configPath, "commands-wiki", "ai")
 	err = filepath.Walk(ai_commands_path, func(path string, info os.FileInfo, err error) error {
@@ -76,45 +111,61 @@ func updateIndex(repo string, branch string) error {
 		return err
 	}
 
-	indexPath := filepath.Join(configPath, "commands-wiki", "index", repo_name)
-	// Create the indexPath if it does not exist
-	err = os.MkdirAll(indexPath, 0744)
-	if err != nil {
-		return err
+	// The hashes of the files the index was built from, only files with a different hash are parsed again
+	previousHashes := make(map[string]string)
+	readIndexMetadata("files", &previousHashes)
+	previousIndex, err := readIndexEnvelope()
+	previousCommands := previousIndex.Commands
+	// Indexes from older versions of cwc are missing fields, build them from scratch
+	isOutdated := previousIndex.migratedFrom != 0
+	for _, cmd := range previousCommands {
+		isOutdated = isOutdated || cmd.SourceFile == ""
 	}
-	indexFilePath := filepath.Join(indexPath, "index")
-	markdownRoot := filepath.Join(indexPath, "cmds")
-	// Remove all files in the markdown root if the directory exists
-	if _, err := os.Stat(markdownRoot); err == nil {
-		err = os.RemoveAll(markdownRoot)
-		if err != nil {
-			return err
+	if err != nil || len(previousHashes) == 0 || isOutdated {
+		// Build the index from scratch
+		previousCommands = nil
+		previousHashes = make(map[string]string)
+		if _, err := os.Stat(legacyMarkdownRoot); err == nil {
+			err = os.RemoveAll(legacyMarkdownRoot)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	// If the index file does not exist, create it
 	if _, err := os.Stat(indexFilePath); os.IsNotExist(err) {
-		log.Infof("creating index")
-		// Create all folders up to that point
-		err := os.MkdirAll(filepath.Dir(indexFilePath), 0744)
+		log.Info("creating index", "indexPath", indexFilePath)
+	}
+
+	hashes := make(map[string]string)
+	changedFiles := make(map[string]string)
+	for _, file := range commandsFiles {
+		contentsBytes, err := os.ReadFile(file)
 		if err != nil {
 			return err
 		}
-		_, err = os.Create(indexFilePath)
-		if err != nil {
-			return err
+		hash := sha256.Sum256(contentsBytes)
+		hashes[file] = hex.EncodeToString(hash[:])
+		if hashes[file] != previousHashes[file] {
+			changedFiles[file] = string(contentsBytes)
 		}
-		log.Info("created index", "indexPath", indexFilePath)
 	}
 
-	var commands []Command
+	// Keep the commands of the files that did not change
+	unchangedCommands := make(map[string][]Command)
+	for _, cmd := range previousCommands {
+		_, isChanged := changedFiles[cmd.SourceFile]
+		if _, exists := hashes[cmd.SourceFile]; exists && !isChanged {
+			unchangedCommands[cmd.SourceFile] = append(unchangedCommands[cmd.SourceFile], cmd)
+		}
+	}
 
-	// Write the index file
+	var commands []Command
 	for _, file := range commandsFiles {
-		// Read the file contents
-		contentsBytes, err := os.ReadFile(file)
-		if err != nil {
-			return err
+		contents, isChanged := changedFiles[file]
+		if !isChanged {
+			commands = append(commands, unchangedCommands[file]...)
+			continue
 		}
 
 		var isAiCommand bool
@@ -122,79 +173,154 @@ func updateIndex(repo string, branch string) error {
 			isAiCommand = true
 		}
 
-		contents := string(contentsBytes)
-		lines := strings.Split(contents, "\n")
-		// Read until "##"
-		var title string
-		var isLookingForCodeblock bool
-		var codeBlockContent string
-		var description string
-		var isLookingForCodeblockEnd bool
-		var metadata map[string]map[string]string = make(map[string]map[string]string)
-		var markdown string
-		for _, line := range lines {
-			if strings.HasPrefix(line, "### ") {
-				if title != "" {
-					addCmd(title, codeBlockContent, &commands, description, markdown, markdownRoot, metadata, isAiCommand)
-				}
-				title = strings.TrimPrefix(line, "### ")
-				description = ""
-				isLookingForCodeblock = true
-				markdown = ""
-				metadata = make(map[string]map[string]string)
-			} else if isLookingForCodeblock && !strings.HasPrefix(line, "```") {
-				description += line + "\n"
-			} else if isLookingForCodeblock && strings.HasPrefix(line, "```") {
-				isLookingForCodeblock = false
-				isLookingForCodeblockEnd = true
-				codeBlockContent = ""
-			} else if isLookingForCodeblockEnd && strings.HasPrefix(line, "```") {
-				isLookingForCodeblockEnd = false
-			} else if isLookingForCodeblockEnd {
-				codeBlockContent += line + "\n"
-			}
-
-			if strings.HasPrefix(line, "[") && strings.Contains(line, "]: <> (") {
-				// [key]: <> (value)
-				// The line looks like above, extract the key and the value
-				re := regexp.MustCompile(`^\[(.*)\]: <> \((.*)\)$`)
-				matches := re.FindStringSubmatch(line)
-				if len(matches) == 3 {
-					// Parse the value
-					var value string = matches[2]
-					// The value looks like: key1=value1 key2="value2"
-					// Extract all keys and values
-					value_re := regexp.MustCompile(`([A-Za-z0-9_]+)=(([^\s"]+)|("[^"]+"))`)
-					value_matches := value_re.FindAllStringSubmatch(value, -1)
-					if len(value_matches) > 0 {
-						metadata[matches[1]] = make(map[string]string)
-						for _, match := range value_matches {
-							metadata[matches[1]][match[1]] = strings.Trim(match[2], "\"")
-						}
-					}
-				}
-			} else {
-				markdown += line + "\n"
-			}
-
+		// The id is the path of the file in the source (or "ai/<file>") and the anchor of the title
+		idRoot := contentPath
+		if isAiCommand {
+			idRoot = filepath.Dir(ai_commands_path)
+		}
+		relativePath, err := filepath.Rel(idRoot, file)
+		if err != nil {
+			relativePath = filepath.Base(file)
 		}
-		if title != "" {
-			addCmd(title, codeBlockContent, &commands, description, markdown, markdownRoot, metadata, isAiCommand)
+		fileCommands := parseCommands(contents, isAiCommand)
+		setCommandIDs(fileCommands, relativePath)
+		for _, cmd := range fileCommands {
+			cmd.SourceFile = file
+			commands = append(commands, cmd)
 		}
 	}
+	log.Info("indexed commands", "changedFiles", len(changedFiles), "files", len(commandsFiles), "commands", len(commands))
 
-	err = writeIndex(commands)
+	err = writeIndexFile(indexFilePath, commands, revision)
+	if err != nil {
+		return err
+	}
+	err = writeIndexMetadata("files", hashes)
 	if err != nil {
 		return err
 	}
 
 	setIndexUpdateTimeToNow()
-	setIndexBranch(branch)
+	setIndexContentRoot(contentRoot)
 
 	return nil
 }
 
-func addCmd(title string, codeBlockContent string, commands *[]Command, description string, markdown string, markdownRoot string, metadata map[string]map[string]string, isAi bool) {
+// parseCommands parses all commands in the markdown contents, a command starts with a "### " title followed by
+// a description and a code block
+func parseCommands(contents string, isAi bool) []Command {
+	var commands []Command
+	lines := strings.Split(contents, "\n")
+	// Read until "##"
+	var title string
+	var isLookingForCodeblock bool
+	var codeBlockContent string
+	var language string
+	var description string
+	var isLookingForCodeblockEnd bool
+	var metadata map[string]map[string]string = make(map[string]map[string]string)
+	var markdown string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "### ") {
+			if title != "" {
+				addCmd(title, codeBlockContent, language, &commands, description, markdown, metadata, isAi)
+			}
+			title = strings.TrimPrefix(line, "### ")
+			description = ""
+			codeBlockContent = ""
+			language = ""
+			isLookingForCodeblock = true
+			markdown = ""
+			metadata = make(map[string]map[string]string)
+		} else if isLookingForCodeblock && !strings.HasPrefix(line, "```") {
+			description += line + "\n"
+		} else if isLookingForCodeblock && strings.HasPrefix(line, "```") {
+			isLookingForCodeblock = false
+			isLookingForCodeblockEnd = true
+			codeBlockContent = ""
+			language = fenceLanguage(line)
+		} else if isLookingForCodeblockEnd && strings.HasPrefix(line, "```") {
+			isLookingForCodeblockEnd = false
+		} else if isLookingForCodeblockEnd {
+			codeBlockContent += line + "\n"
+		}
+
+		if strings.HasPrefix(line, "[") && strings.Contains(line, "]: <> (") {
+			// [key]: <> (value)
+			// The line looks like above, extract the key and the value
+			re := regexp.MustCompile(`^\[(.*)\]: <> \((.*)\)$`)
+			matches := re.FindStringSubmatch(line)
+			if len(matches) == 3 {
+				// Parse the value
+				var value string = matches[2]
+				// The value looks like: key1=value1 key2="value2"
+				// Extract all keys and values
+				value_re := regexp.MustCompile(`([A-Za-z0-9_]+)=(([^\s"]+)|("[^"]+"))`)
+				value_matches := value_re.FindAllStringSubmatch(value, -1)
+				if len(value_matches) > 0 {
+					metadata[matches[1]] = make(map[string]string)
+					for _, match := range value_matches {
+						metadata[matches[1]][match[1]] = strings.Trim(match[2], "\"")
+					}
+				}
+			}
+		} else {
+			markdown += line + "\n"
+		}
+
+	}
+	if title != "" {
+		addCmd(title, codeBlockContent, language, &commands, description, markdown, metadata, isAi)
+	}
+	return commands
+}
+
+// commandID returns the stable id of the command with the title in the markdown file at relativePath, like
+// "network/interfaces#create-a-dummy-networking-interface"
+func commandID(relativePath string, title string) string {
+	return strings.TrimSuffix(filepath.ToSlash(relativePath), ".md") + "#" + headingAnchor(title)
+}
+
+// setCommandIDs sets the ids of the commands parsed from the markdown file at relativePath, like the wiki the
+// anchors of repeated titles get a "-1", "-2", ... suffix
+func setCommandIDs(commands []Command, relativePath string) {
+	used := make(map[string]bool)
+	for i := range commands {
+		base := commandID(relativePath, commands[i].CmdTitle)
+		id := base
+		for n := 1; used[id]; n++ {
+			id = base + "-" + strconv.Itoa(n)
+		}
+		used[id] = true
+		commands[i].ID = id
+	}
+}
+
+// headingAnchor returns the anchor the wiki generates for a heading, "Create a dummy (vip) interface" returns
+// "create-a-dummy-vip-interface"
+func headingAnchor(title string) string {
+	var anchor strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(title)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			anchor.WriteRune(r)
+		case r == ' ':
+			anchor.WriteRune('-')
+		}
+	}
+	return anchor.String()
+}
+
+// fenceLanguage returns the language from the info string of an opening code fence, "```bash title=x" returns "bash"
+func fenceLanguage(line string) string {
+	info := strings.Fields(strings.TrimLeft(line, "`"))
+	if len(info) == 0 {
+		return ""
+	}
+	return strings.ToLower(info[0])
+}
+
+func addCmd(title string, codeBlockContent string, language string, commands *[]Command, description string, markdown string, metadata map[string]map[string]string, isAi bool) {
 	// Extract the names of the variables inside of {}, <>
 	codeBlockLines := strings.Split(codeBlockContent, "\n")
 	var variables []string
@@ -211,32 +337,14 @@ func addCmd(title string, codeBlockContent string, commands *[]Command, descript
 	codeBlockContent = strings.TrimSuffix(codeBlockContent, "\n")
 	description = strings.TrimSuffix(description, "\n")
 
-	// Write the markdown file
-	markdownFilePath := filepath.Join(markdownRoot, title+".md")
-	err := os.MkdirAll(filepath.Dir(markdownFilePath), 0744)
-	if err != nil {
-		log.Fatal("Failed to create markdown file parent directories", "error", err)
-	}
-	markdownFile, err := os.Create(markdownFilePath)
-	if err != nil {
-		log.Fatal("Failed to create markdown file", "error", err)
-	}
-	_, err = markdownFile.WriteString(markdown)
-	if err != nil {
-		log.Fatal("Failed to write markdown content", "error", err)
-	}
-	err = markdownFile.Close()
-	if err != nil {
-		log.Fatal("Failed to close markdown file", "error", err)
-	}
-
 	// Write the command to the index serialized as json
 	cmd := Command{
 		CmdTitle:       title,
 		Content:        codeBlockContent,
+		Language:       language,
 		Variables:      variables,
 		CmdDescription: description,
-		MarkdownFile:   markdownFilePath,
+		Markdown:       markdown,
 		Metadata:       metadata,
 		AiGenerated:    isAi,
 	}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		expected []Command
+	}{
+		{
+			name:     "command with code block",
+			contents: "### List files\nList all files\n```bash\nls -la\n```\n",
+			expected: []Command{{CmdTitle: "List files", CmdDescription: "List all files", Content: "ls -la", Language: "bash"}},
+		},
+		{
+			name: "second command without code block",
+			contents: "### Python version\n```python\nimport sys\nprint(sys.version)\n```\n" +
+				"### Reboot\nRestart the machine from the power menu",
+			expected: []Command{
+				{CmdTitle: "Python version", Content: "import sys\nprint(sys.version)", Language: "python"},
+				{CmdTitle: "Reboot", CmdDescription: "Restart the machine from the power menu"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			commands := parseCommands(test.contents, false)
+			if len(commands) != len(test.expected) {
+				t.Fatalf("expected %d commands, got %d", len(test.expected), len(commands))
+			}
+			for i, expected := range test.expected {
+				cmd := commands[i]
+				if cmd.CmdTitle != expected.CmdTitle || cmd.CmdDescription != expected.CmdDescription ||
+					cmd.Content != expected.Content || cmd.Language != expected.Language {
+					t.Errorf("command %d: expected %q %q %q %q, got %q %q %q %q", i,
+						expected.CmdTitle, expected.CmdDescription, expected.Content, expected.Language,
+						cmd.CmdTitle, cmd.CmdDescription, cmd.Content, cmd.Language)
+				}
+			}
+		})
+	}
+}
+
+// indexedContents returns the content of the indexed commands by their title
+func indexedContents(t *testing.T) map[string]string {
+	commands, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]string)
+	for _, cmd := range commands {
+		contents[cmd.CmdTitle] = cmd.Content
+	}
+	return contents
+}
+
+// tamperIndex changes the content of the indexed commands, commands that are parsed again lose the change
+func tamperIndex(t *testing.T) {
+	err := modifyIndex(func(commands []Command) []Command {
+		for i := range commands {
+			commands[i].Content = "tampered"
+		}
+		return commands
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeCommandFile(t *testing.T, path string, title string, content string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte("### "+title+"\n```bash\n"+content+"\n```\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateIndexOnlyParsesChangedFiles(t *testing.T) {
+	wiki := t.TempDir()
+	setupTestConfig(t, "repo "+wiki+"\n")
+	commandsPath := filepath.Join(wiki, "commands")
+	writeCommandFile(t, filepath.Join(commandsPath, "a.md"), "A", "echo a")
+	writeCommandFile(t, filepath.Join(commandsPath, "b.md"), "B", "echo b")
+	writeCommandFile(t, filepath.Join(commandsPath, "c.md"), "C", "echo c")
+	err := updateIndex(wiki, wikiRef{}, "commands")
+	if err != nil {
+		t.Fatalf("first updateIndex() error = %v", err)
+	}
+	want := map[string]string{"A": "echo a", "B": "echo b", "C": "echo c"}
+	if got := indexedContents(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("index = %v, want %v", got, want)
+	}
+
+	tamperIndex(t)
+	writeCommandFile(t, filepath.Join(commandsPath, "b.md"), "B", "echo b2")
+	err = os.Remove(filepath.Join(commandsPath, "c.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateIndex(wiki, wikiRef{}, "commands")
+	if err != nil {
+		t.Fatalf("second updateIndex() error = %v", err)
+	}
+	// a.md did not change and keeps its indexed command, b.md is parsed again and c.md is dropped
+	want = map[string]string{"A": "tampered", "B": "echo b2"}
+	if got := indexedContents(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("index = %v, want %v", got, want)
+	}
+}
+
+func TestUpdateIndexSkipsAnUnchangedRevision(t *testing.T) {
+	remote := newTestRemote(t)
+	setupTestConfig(t, "repo "+remote.url+"\n")
+	remote.push("main", "commands/a.md", "### A\n```bash\necho a\n```\n")
+	err := updateIndex(remote.url, wikiRef{Branch: "main"}, "commands")
+	if err != nil {
+		t.Fatalf("first updateIndex() error = %v", err)
+	}
+
+	// Nothing is parsed again while the revision stays the same, without the hashes of the files the index would
+	// be built from scratch if the update was not skipped
+	tamperIndex(t)
+	err = writeIndexMetadata("files", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateIndex(remote.url, wikiRef{Branch: "main"}, "commands")
+	if err != nil {
+		t.Fatalf("second updateIndex() error = %v", err)
+	}
+	want := map[string]string{"A": "tampered"}
+	if got := indexedContents(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("index = %v, want %v", got, want)
+	}
+
+	remote.push("main", "commands/a.md", "### A\n```bash\necho a2\n```\n")
+	err = updateIndex(remote.url, wikiRef{Branch: "main"}, "commands")
+	if err != nil {
+		t.Fatalf("third updateIndex() error = %v", err)
+	}
+	want = map[string]string{"A": "echo a2"}
+	if got := indexedContents(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("index = %v, want %v", got, want)
+	}
+}
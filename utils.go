@@ -10,11 +10,25 @@ import (
 // This function is used to execute a command with arguments
 // It returns the command object
 func execCommand(command string, args []string) *exec.Cmd {
+	return execCommandWithEnv(command, args, nil)
+}
+
+// execCommandWithEnv works like execCommand but adds env ("KEY=value") to the environment of the command
+func execCommandWithEnv(command string, args []string, env []string) *exec.Cmd {
 	cmd := exec.Command(command, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	runAttached(cmd)
+	return cmd
+}
+
+// runAttached runs the command in the temp directory with the input and output of cwc
+// It returns the error of cmd.Run, an *exec.ExitError if the command exited with a non-zero code
+func runAttached(cmd *exec.Cmd) error {
 	cmd.Dir = os.TempDir()
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
-	cmd.Run()
-	return cmd
+	return cmd.Run()
 }